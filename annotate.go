@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Annotate attaches the delay measured by a block, mutex or CPU profile to
+// the goroutines whose stacks match, so that they can be ranked by delay
+// rather than by count, e.g. x.sort("delay desc").
+func (gd *GoroutineDump) Annotate(fn string) error {
+	fn = strings.Trim(fn, "\"")
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	annotated, total, err := gd.GoroutineDump.Annotate(f)
+	if err != nil {
+		return fmt.Errorf("%s: %s", fn, err)
+	}
+	fmt.Printf("Annotated %d goroutines with %s of delay.\n", annotated, total.Round(time.Millisecond))
+	return nil
+}
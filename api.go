@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/linuxerwang/goroutine-inspect/dump"
+)
+
+const (
+	// apiMaxUpload is the maximum size of an uploaded dump.
+	apiMaxUpload = 64 << 20
+	// apiMaxRequest is the maximum size of the JSON body of an operation.
+	apiMaxRequest = 64 << 10
+)
+
+// apiError is an error replied with the HTTP status code.
+type apiError struct {
+	code int
+	err  error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &apiError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+// api serves the HTTP JSON API over the dumps of the server, for tools to
+// run the same operations as the interactive shell:
+//
+//	GET    /api/vars                          List the dumps.
+//	POST   /api/vars/<name>                   Load the dump in the body.
+//	GET    /api/vars/<name>                   Show the summary.
+//	DELETE /api/vars/<name>                   Remove the dump.
+//	GET    /api/vars/<name>/goroutines        List the goroutines.
+//	POST   /api/vars/<name>/<op>              Run an operation, see apiOps.
+//
+// Requests are served under the write lock of the server, as operations
+// modify the dumps in place. A dump is posted as application/octet-stream,
+// and an operation as application/json, so that a web page can't post to
+// the API without a CORS preflight, which it doesn't answer.
+type api struct {
+	s *server
+}
+
+// apiRequest is the JSON body of an operation.
+type apiRequest struct {
+	Cond   string   `json:"cond"`   // The condition of keep, delete and search.
+	Mode   string   `json:"mode"`   // The mode of dedup, dedup_mode by default.
+	Other  string   `json:"other"`  // The dump to diff with.
+	Names  []string `json:"names"`  // The names to set the diff results as, if any.
+	Offset int      `json:"offset"` // The offset of search results.
+	Limit  int      `json:"limit"`  // The limit of search results, show_limit by default.
+}
+
+// apiOps are the operations on a dump, each replying the JSON value
+// returned. They call the library rather than the methods of the shell, which
+// print.
+var apiOps = map[string]func(s *server, gd *GoroutineDump, req *apiRequest) (interface{}, error){
+	"dedup": func(s *server, gd *GoroutineDump, req *apiRequest) (interface{}, error) {
+		mode := req.Mode
+		if mode == "" {
+			mode = settings.DedupMode
+		}
+		if err := gd.GoroutineDump.Dedup(mode); err != nil {
+			return nil, badRequest("%s", err)
+		}
+		return newSummaryJSON("", gd), nil
+	},
+	"delete": func(s *server, gd *GoroutineDump, req *apiRequest) (interface{}, error) {
+		if req.Cond == "" {
+			return nil, badRequest("cond is required")
+		}
+		c, err := compile(req.Cond)
+		if err != nil {
+			return nil, badRequest("%s", err)
+		}
+		if err := gd.GoroutineDump.Delete(c); err != nil {
+			return nil, badRequest("%s", err)
+		}
+		return newSummaryJSON("", gd), nil
+	},
+	"diff": func(s *server, gd *GoroutineDump, req *apiRequest) (interface{}, error) {
+		another, ok := s.dumps[req.Other]
+		if !ok {
+			return nil, badRequest("dump %q not found", req.Other)
+		}
+		if len(req.Names) > 3 {
+			return nil, badRequest("diff returns 3 dumps, got %d names", len(req.Names))
+		}
+		for _, name := range req.Names {
+			if !identifierPattern.MatchString(name) {
+				return nil, badRequest("invalid dump name %s", name)
+			}
+		}
+		lonly, common, ronly := gd.Diff(another)
+		res := results{lonly, common, ronly}
+		for i, name := range req.Names {
+			s.set(name, res[i])
+		}
+		return map[string]*summaryJSON{
+			"left_only":  newSummaryJSON("", lonly),
+			"common":     newSummaryJSON("", common),
+			"right_only": newSummaryJSON("", ronly),
+		}, nil
+	},
+	"keep": func(s *server, gd *GoroutineDump, req *apiRequest) (interface{}, error) {
+		if req.Cond == "" {
+			return nil, badRequest("cond is required")
+		}
+		c, err := compile(req.Cond)
+		if err != nil {
+			return nil, badRequest("%s", err)
+		}
+		if err := gd.GoroutineDump.Keep(c); err != nil {
+			return nil, badRequest("%s", err)
+		}
+		return newSummaryJSON("", gd), nil
+	},
+	"search": func(s *server, gd *GoroutineDump, req *apiRequest) (interface{}, error) {
+		if req.Cond == "" {
+			return nil, badRequest("cond is required")
+		}
+		matched, err := gd.filter(req.Cond)
+		if err != nil {
+			return nil, badRequest("%s", err)
+		}
+		limit := req.Limit
+		if limit == 0 {
+			limit = settings.ShowLimit
+		}
+		if req.Offset < 0 || limit < 0 {
+			return nil, badRequest("negative offset or limit")
+		}
+		return newGoroutinesJSON(matched.Goroutines(), req.Offset, limit), nil
+	},
+}
+
+// summaryJSON is the summary of a dump, as summary() prints.
+type summaryJSON struct {
+	Name       string         `json:"name,omitempty"`
+	Goroutines int            `json:"goroutines"`
+	States     map[string]int `json:"states"`
+	Flags      map[string]int `json:"flags"`
+	IDs        []int          `json:"ids"`
+}
+
+func newSummaryJSON(name string, gd *GoroutineDump) *summaryJSON {
+	states, flags := gd.Stats()
+	ids := make([]int, 0, len(gd.Goroutines()))
+	for _, g := range gd.Goroutines() {
+		ids = append(ids, g.ID())
+	}
+	return &summaryJSON{Name: name, Goroutines: len(gd.Goroutines()), States: states, Flags: flags, IDs: ids}
+}
+
+type frameJSON struct {
+	Func string `json:"func"`
+	Args string `json:"args,omitempty"`
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+}
+
+func newFrameJSON(f *dump.Frame) *frameJSON {
+	return &frameJSON{Func: f.Func(), Args: f.Args(), File: f.File(), Line: f.Line()}
+}
+
+type goroutineJSON struct {
+	ID         int               `json:"id"`
+	Header     string            `json:"header"`
+	State      string            `json:"state"`
+	Duration   int               `json:"duration"`
+	Locked     bool              `json:"locked"`
+	Flags      []string          `json:"flags,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Duplicates []int             `json:"duplicates,omitempty"`
+	Frames     []*frameJSON      `json:"frames"`
+	CreatedBy  *frameJSON        `json:"created_by,omitempty"`
+	Trace      string            `json:"trace"`
+}
+
+func newGoroutineJSON(g *dump.Goroutine) *goroutineJSON {
+	gj := &goroutineJSON{
+		ID:         g.ID(),
+		Header:     g.Header(),
+		State:      g.State(),
+		Duration:   g.Duration(),
+		Locked:     g.Locked(),
+		Flags:      g.Flags(),
+		Labels:     g.Labels(),
+		Duplicates: g.Duplicates(),
+		Frames:     make([]*frameJSON, 0, len(g.Frames())),
+		Trace:      g.Trace(),
+	}
+	for _, f := range g.Frames() {
+		gj.Frames = append(gj.Frames, newFrameJSON(f))
+	}
+	if g.CreatedBy() != nil {
+		gj.CreatedBy = newFrameJSON(g.CreatedBy())
+	}
+	return gj
+}
+
+// goroutinesJSON is a page of goroutines.
+type goroutinesJSON struct {
+	Total      int              `json:"total"`
+	Goroutines []*goroutineJSON `json:"goroutines"`
+}
+
+func newGoroutinesJSON(goroutines []*dump.Goroutine, offset, limit int) *goroutinesJSON {
+	gj := &goroutinesJSON{Total: len(goroutines), Goroutines: []*goroutineJSON{}}
+	for i := offset; i < offset+limit && i < len(goroutines); i++ {
+		gj.Goroutines = append(gj.Goroutines, newGoroutineJSON(goroutines[i]))
+	}
+	return gj
+}
+
+func (a *api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	v, err := a.serve(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		code := http.StatusInternalServerError
+		var ae *apiError
+		if errors.As(err, &ae) {
+			code = ae.code
+		}
+		w.WriteHeader(code)
+		v = map[string]string{"error": err.Error()}
+	} else if v == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// serve runs the request and returns the value to reply.
+func (a *api) serve(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/")
+	if parts[0] != "vars" || len(parts) > 3 {
+		return nil, &apiError{http.StatusNotFound, fmt.Errorf("%s not found", r.URL.Path)}
+	}
+	methodNotAllowed := &apiError{http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			return nil, methodNotAllowed
+		}
+		vars := []*summaryJSON{}
+		for _, name := range a.s.names {
+			vars = append(vars, newSummaryJSON(name, a.s.dumps[name]))
+		}
+		return vars, nil
+	}
+
+	name := parts[1]
+	if len(parts) == 2 && r.Method == http.MethodPost {
+		if !identifierPattern.MatchString(name) {
+			return nil, badRequest("invalid dump name %s", name)
+		}
+		if err := checkContentType(r, "application/octet-stream"); err != nil {
+			return nil, err
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, apiMaxUpload)
+		// Without the warnings of loadFrom(), malformed sections are
+		// skipped quietly.
+		parsed, err := (&dump.Parser{Rewrites: settings.PathRewrites}).Parse(r.Body)
+		if err != nil {
+			return nil, badRequest("%s", err)
+		}
+		gd := &GoroutineDump{parsed}
+		a.s.set(name, gd)
+		return newSummaryJSON(name, gd), nil
+	}
+	gd, ok := a.s.dumps[name]
+	if !ok {
+		return nil, &apiError{http.StatusNotFound, fmt.Errorf("dump %q not found", name)}
+	}
+
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			return newSummaryJSON(name, gd), nil
+		case http.MethodDelete:
+			a.s.remove(name)
+			return nil, nil
+		}
+		return nil, methodNotAllowed
+	}
+
+	op := parts[2]
+	if op == "goroutines" {
+		if r.Method != http.MethodGet {
+			return nil, methodNotAllowed
+		}
+		offset, limit := 0, settings.ShowLimit
+		for p, v := range map[string]*int{"offset": &offset, "limit": &limit} {
+			if s := r.URL.Query().Get(p); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil || n < 0 {
+					return nil, badRequest("invalid %s %s", p, s)
+				}
+				*v = n
+			}
+		}
+		return newGoroutinesJSON(gd.Goroutines(), offset, limit), nil
+	}
+	call, ok := apiOps[op]
+	if !ok {
+		return nil, &apiError{http.StatusNotFound, fmt.Errorf("unknown operation %s", op)}
+	}
+	if r.Method != http.MethodPost {
+		return nil, methodNotAllowed
+	}
+	if err := checkContentType(r, "application/json"); err != nil {
+		return nil, err
+	}
+	req := &apiRequest{}
+	r.Body = http.MaxBytesReader(w, r.Body, apiMaxRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+		return nil, badRequest("invalid request: %s", err)
+	}
+	return call(a.s, gd, req)
+}
+
+// checkContentType returns an error unless the request body is of the media
+// type.
+func checkContentType(r *http.Request, want string) error {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mt != want {
+		return &apiError{http.StatusUnsupportedMediaType, fmt.Errorf("content type must be %s", want)}
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// apiCall sends a request to the API and returns the status code and the
+// decoded JSON reply. A dump is posted as application/octet-stream, and an
+// operation as application/json.
+func apiCall(t *testing.T, s *server, method, path, body string) (int, interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if method == http.MethodPost {
+		if strings.Count(path, "/") == 3 {
+			req.Header.Set("Content-Type", "application/octet-stream")
+		} else {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	}
+	rec := httptest.NewRecorder()
+	(&api{s}).ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: content type %q", method, path, ct)
+	}
+	var v interface{}
+	if rec.Code != http.StatusNoContent {
+		if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+			t.Fatalf("%s %s: invalid reply %q: %s", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code, v
+}
+
+// apiServer returns a server with the sample dump loaded as x by the API.
+func apiServer(t *testing.T) *server {
+	t.Helper()
+	s := &server{dumps: map[string]*GoroutineDump{}}
+	if code, v := apiCall(t, s, http.MethodPost, "/api/vars/x", sampleDump); code != http.StatusOK {
+		t.Fatalf("loading x: %d %v", code, v)
+	}
+	return s
+}
+
+// replyIds returns the ids of a summary, or of a page of goroutines.
+func replyIds(v interface{}) []int {
+	ids := []int{}
+	m, _ := v.(map[string]interface{})
+	if l, ok := m["ids"].([]interface{}); ok {
+		for _, id := range l {
+			ids = append(ids, int(id.(float64)))
+		}
+	}
+	if l, ok := m["goroutines"].([]interface{}); ok {
+		for _, g := range l {
+			ids = append(ids, int(g.(map[string]interface{})["id"].(float64)))
+		}
+	}
+	return ids
+}
+
+func TestAPIVars(t *testing.T) {
+	s := apiServer(t)
+
+	code, v := apiCall(t, s, http.MethodGet, "/api/vars", "")
+	if l, ok := v.([]interface{}); code != http.StatusOK || !ok || len(l) != 1 {
+		t.Errorf("GET /api/vars = %d %v, want x only", code, v)
+	}
+	code, v = apiCall(t, s, http.MethodGet, "/api/vars/x", "")
+	if code != http.StatusOK || !reflect.DeepEqual(replyIds(v), []int{1, 5, 6, 7}) {
+		t.Errorf("GET /api/vars/x = %d %v", code, v)
+	}
+	if v.(map[string]interface{})["states"].(map[string]interface{})["chan receive"] != 3.0 {
+		t.Errorf("GET /api/vars/x states = %v", v)
+	}
+
+	if code, _ := apiCall(t, s, http.MethodDelete, "/api/vars/x", ""); code != http.StatusNoContent {
+		t.Errorf("DELETE /api/vars/x = %d, want %d", code, http.StatusNoContent)
+	}
+	if code, _ := apiCall(t, s, http.MethodGet, "/api/vars/x", ""); code != http.StatusNotFound {
+		t.Errorf("GET /api/vars/x after deleting = %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestAPIGoroutines(t *testing.T) {
+	s := apiServer(t)
+
+	code, v := apiCall(t, s, http.MethodGet, "/api/vars/x/goroutines?offset=1&limit=2", "")
+	if code != http.StatusOK || !reflect.DeepEqual(replyIds(v), []int{5, 6}) || v.(map[string]interface{})["total"] != 4.0 {
+		t.Fatalf("GET goroutines = %d %v", code, v)
+	}
+	g := v.(map[string]interface{})["goroutines"].([]interface{})[0].(map[string]interface{})
+	frame := g["frames"].([]interface{})[0].(map[string]interface{})
+	if g["state"] != "chan receive" || g["duration"] != 12.0 || frame["func"] != "main.worker" || frame["line"] != 20.0 {
+		t.Errorf("goroutine 5 = %v", g)
+	}
+	if createdBy := g["created_by"].(map[string]interface{}); createdBy["func"] != "main.main" {
+		t.Errorf("goroutine 5 created by %v", createdBy)
+	}
+}
+
+func TestAPIOps(t *testing.T) {
+	for _, tc := range []struct {
+		op   string
+		body string
+		want []int
+	}{
+		{"dedup", `{"mode": "lines"}`, []int{1, 5, 7}},
+		{"dedup", `{"mode": "funcs"}`, []int{1, 5}},
+		{"keep", `{"cond": "duration > 10"}`, []int{5, 6}},
+		{"delete", `{"cond": "duration > 10"}`, []int{1, 7}},
+		{"search", `{"cond": "id > 5"}`, []int{6, 7}},
+		{"search", `{"cond": "id > 1", "offset": 1, "limit": 1}`, []int{6}},
+	} {
+		s := apiServer(t)
+		code, v := apiCall(t, s, http.MethodPost, "/api/vars/x/"+tc.op, tc.body)
+		if got := replyIds(v); code != http.StatusOK || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s %s = %d %v, want ids %v", tc.op, tc.body, code, v, tc.want)
+		}
+	}
+
+	// Search leaves the dump as it is, while the others change it in place.
+	s := apiServer(t)
+	apiCall(t, s, http.MethodPost, "/api/vars/x/search", `{"cond": "id > 5"}`)
+	apiCall(t, s, http.MethodPost, "/api/vars/x/keep", `{"cond": "id > 1"}`)
+	if _, v := apiCall(t, s, http.MethodGet, "/api/vars/x", ""); !reflect.DeepEqual(replyIds(v), []int{5, 6, 7}) {
+		t.Errorf("x after search and keep = %v", v)
+	}
+}
+
+func TestAPIDiff(t *testing.T) {
+	s := apiServer(t)
+	apiCall(t, s, http.MethodPost, "/api/vars/y", strings.Replace(sampleDump, "goroutine 7 ", "goroutine 8 ", 1))
+
+	code, v := apiCall(t, s, http.MethodPost, "/api/vars/x/diff", `{"other": "y", "names": ["a", "b"]}`)
+	if code != http.StatusOK {
+		t.Fatalf("diff = %d %v", code, v)
+	}
+	res := v.(map[string]interface{})
+	for key, want := range map[string][]int{"left_only": {7}, "right_only": {8}} {
+		if got := replyIds(res[key]); !reflect.DeepEqual(got, want) {
+			t.Errorf("diff %s = %v, want %v", key, got, want)
+		}
+	}
+	if len(replyIds(res["common"])) != 3 {
+		t.Errorf("diff common = %v, want 3 goroutines", res["common"])
+	}
+	if ids := replyIds(apiGet(t, s, "/api/vars/a")); !reflect.DeepEqual(ids, []int{7}) {
+		t.Errorf("diff set a to %v, want the left only goroutines", ids)
+	}
+	if _, ok := s.dumps["c"]; ok {
+		t.Error("diff set c, which isn't named")
+	}
+	if want := []string{"x", "y", "a", "b"}; !reflect.DeepEqual(s.names, want) {
+		t.Errorf("dumps after diff = %q, want %q", s.names, want)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{http.MethodGet, "/api/dumps", "", http.StatusNotFound},
+		{http.MethodGet, "/api/vars/x/goroutines/1", "", http.StatusNotFound},
+		{http.MethodPost, "/api/vars", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/vars/1x", sampleDump, http.StatusBadRequest},
+		{http.MethodPost, "/api/vars/y", "\x1f\x8bnot a profile", http.StatusBadRequest},
+		{http.MethodGet, "/api/vars/nope", "", http.StatusNotFound},
+		{http.MethodPut, "/api/vars/x", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/vars/x/goroutines", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/vars/x/goroutines?limit=-1", "", http.StatusBadRequest},
+		{http.MethodGet, "/api/vars/x/goroutines?offset=a", "", http.StatusBadRequest},
+		{http.MethodPost, "/api/vars/x/sort", "{}", http.StatusNotFound},
+		{http.MethodGet, "/api/vars/x/dedup", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/vars/x/dedup", "{", http.StatusBadRequest},
+		{http.MethodPost, "/api/vars/x/dedup", `{"mode": "stacks"}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/vars/x/keep", "{}", http.StatusBadRequest},
+		{http.MethodPost, "/api/vars/x/delete", `{"cond": "id >"}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/vars/x/search", `{"cond": "state"}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/vars/x/search", `{"cond": "true", "offset": -1}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/vars/x/diff", `{"other": "nope"}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/vars/x/diff", `{"other": "x", "names": ["a", "b", "c", "d"]}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/vars/x/diff", `{"other": "x", "names": ["1a"]}`, http.StatusBadRequest},
+	} {
+		s := apiServer(t)
+		code, v := apiCall(t, s, tc.method, tc.path, tc.body)
+		if code != tc.code {
+			t.Errorf("%s %s %s = %d %v, want %d", tc.method, tc.path, tc.body, code, v, tc.code)
+			continue
+		}
+		if m, ok := v.(map[string]interface{}); !ok || m["error"] == "" {
+			t.Errorf("%s %s %s = %v, want an error", tc.method, tc.path, tc.body, v)
+		}
+	}
+}
+
+func TestAPIPrintsNothing(t *testing.T) {
+	out := captureStdout(t, func() {
+		s := apiServer(t)
+		apiCall(t, s, http.MethodPost, "/api/vars/y", "goroutine 3 [running\n\n"+sampleDump)
+		apiCall(t, s, http.MethodPost, "/api/vars/x/dedup", `{"mode": "funcs"}`)
+		apiCall(t, s, http.MethodPost, "/api/vars/y/keep", `{"cond": "id > 1"}`)
+		apiCall(t, s, http.MethodPost, "/api/vars/y/delete", `{"cond": "id > 5"}`)
+		apiCall(t, s, http.MethodPost, "/api/vars/x/diff", `{"other": "y", "names": ["a"]}`)
+		apiCall(t, s, http.MethodDelete, "/api/vars/y", "")
+	})
+	if out != "" {
+		t.Errorf("the API printed %q", out)
+	}
+}
+
+// apiGet gets a reply of the API, which must succeed.
+func apiGet(t *testing.T, s *server, path string) interface{} {
+	t.Helper()
+	code, v := apiCall(t, s, http.MethodGet, path, "")
+	if code != http.StatusOK {
+		t.Fatalf("GET %s = %d %v", path, code, v)
+	}
+	return v
+}
+
+func TestAPIServedDumps(t *testing.T) {
+	s := testServer(t)
+	var names []string
+	for _, v := range apiGet(t, s, "/api/vars").([]interface{}) {
+		names = append(names, v.(map[string]interface{})["name"].(string))
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("GET /api/vars = %q, want %q", names, want)
+	}
+
+	// The web UI shows the changes by the API, and a goroutine kept by
+	// dedup counts as all of its duplicates.
+	apiCall(t, s, http.MethodPost, "/api/vars/a/dedup", `{"mode": "lines"}`)
+	checkPage(t, s, "/dump?name=a",
+		"3 goroutines matched, in 3 groups.",
+		`<span class="count">2</span> goroutines:`)
+	apiCall(t, s, http.MethodPost, "/api/vars/c", sampleDump)
+	apiCall(t, s, http.MethodDelete, "/api/vars/b", "")
+	checkPage(t, s, "/", `<a href="/dump?name=c">c</a>`)
+	if code, _ := get(t, s, "/dump?name=b"); code != http.StatusNotFound {
+		t.Errorf("GET /dump?name=b after deleting = %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestAPIContentType(t *testing.T) {
+	s := apiServer(t)
+	for _, tc := range []struct {
+		path, contentType, body string
+		code                    int
+	}{
+		{"/api/vars/y", "", sampleDump, http.StatusUnsupportedMediaType},
+		{"/api/vars/y", "text/plain", sampleDump, http.StatusUnsupportedMediaType},
+		{"/api/vars/y", "application/json", sampleDump, http.StatusUnsupportedMediaType},
+		{"/api/vars/y", "application/octet-stream", sampleDump, http.StatusOK},
+		{"/api/vars/x/keep", "", `{"cond": "id > 1"}`, http.StatusUnsupportedMediaType},
+		{"/api/vars/x/keep", "application/x-www-form-urlencoded", `{"cond": "id > 1"}`, http.StatusUnsupportedMediaType},
+		{"/api/vars/x/keep", "text/plain", `{"cond": "id > 1"}`, http.StatusUnsupportedMediaType},
+		{"/api/vars/x/keep", "application/json; charset=utf-8", `{"cond": "id > 1"}`, http.StatusOK},
+		{"/api/vars/x/keep", "application/json", `{"cond": "` + strings.Repeat(" ", apiMaxRequest) + `id > 1"}`, http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		rec := httptest.NewRecorder()
+		(&api{s}).ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Errorf("POST %s as %q = %d %s, want %d", tc.path, tc.contentType, rec.Code, rec.Body.String(), tc.code)
+		}
+	}
+	if _, ok := s.dumps["y"]; !ok {
+		t.Error("the dump posted as application/octet-stream isn't loaded")
+	}
+	if ids := replyIds(apiGet(t, s, "/api/vars/x")); !reflect.DeepEqual(ids, []int{5, 6, 7}) {
+		t.Errorf("x = %v, want only kept as application/json", ids)
+	}
+}
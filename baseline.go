@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/linuxerwang/goroutine-inspect/dump"
+	"github.com/linuxerwang/goroutine-inspect/internal/text"
+)
+
+// baselineHeader starts the baseline files written by SaveBaseline.
+const baselineHeader = `# Baseline of known-benign goroutines.
+#
+# Goroutines calling the same functions as a "stack" entry, or matching a
+# "cond" entry, are removed by suppress(). Lines starting with "#" are
+# comments. A condition is written in one line, e.g.:
+#
+#	cond contains(trace, 'grpc') && state == 'select'
+#
+# A stack lists the function names indented, the innermost first.
+`
+
+// baseline is a list of known-benign goroutines.
+type baseline struct {
+	conds  []string
+	stacks map[string]bool // The signatures, see dump.StackSignature.
+}
+
+// loadBaseline reads a baseline file in the form of:
+//
+//	# Comments.
+//	cond state == 'IO wait' && in_package('net/http')
+//
+//	stack
+//		runtime.gopark
+//		main.worker
+//		created by main.main
+func loadBaseline(fn string) (*baseline, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	bl := &baseline{stacks: map[string]bool{}}
+	var stack []string
+	inStack, stackLine := false, 0
+	endStack := func() error {
+		if !inStack {
+			return nil
+		}
+		inStack = false
+		if len(stack) == 0 {
+			return fmt.Errorf("%s:%d: empty stack", fn, stackLine)
+		}
+		createdBy := ""
+		if last := stack[len(stack)-1]; strings.HasPrefix(last, "created by ") {
+			createdBy = strings.TrimPrefix(last, "created by ")
+			stack = stack[:len(stack)-1]
+		}
+		bl.stacks[dump.StackSignature(stack, createdBy)] = true
+		stack = nil
+		return nil
+	}
+
+	var lineErr error
+	err = text.ReadLines(f, func(n int, l string) {
+		if lineErr != nil {
+			return
+		}
+		trimmed := strings.TrimSpace(l)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+		case inStack && (strings.HasPrefix(l, "\t") || strings.HasPrefix(l, " ")):
+			stack = append(stack, trimmed)
+		case trimmed == "stack":
+			lineErr = endStack()
+			inStack, stackLine = true, n
+		case strings.HasPrefix(trimmed, "cond "):
+			if lineErr = endStack(); lineErr != nil {
+				return
+			}
+			cond := strings.TrimSpace(strings.TrimPrefix(trimmed, "cond "))
+			if _, err := dump.CompileCondition(cond, nil); err != nil {
+				lineErr = fmt.Errorf("%s:%d: %s", fn, n, err)
+				return
+			}
+			bl.conds = append(bl.conds, cond)
+		default:
+			lineErr = fmt.Errorf("%s:%d: expect \"cond <condition>\" or \"stack\"", fn, n)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if lineErr != nil {
+		return nil, lineErr
+	}
+	if err := endStack(); err != nil {
+		return nil, err
+	}
+	return bl, nil
+}
+
+// Suppress removes the goroutines listed in a baseline file.
+func (gd *GoroutineDump) Suppress(fn string) error {
+	bl, err := loadBaseline(fn)
+	if err != nil {
+		return err
+	}
+
+	cond := "false"
+	if len(bl.conds) > 0 {
+		cond = "(" + strings.Join(bl.conds, ") || (") + ")"
+	}
+	c, err := compile(cond)
+	if err != nil {
+		return err
+	}
+	signature := dump.DedupModes["funcs"]
+	kept := dump.New()
+	for _, g := range gd.Goroutines() {
+		matched, err := c.Match(g)
+		if err != nil {
+			return err
+		}
+		if !matched && !bl.stacks[signature(g)] {
+			kept.Add(g)
+		}
+	}
+	fmt.Printf("Deleted %d goroutines, kept %d.\n", len(gd.Goroutines())-len(kept.Goroutines()), len(kept.Goroutines()))
+	gd.GoroutineDump = kept
+	return nil
+}
+
+// SaveBaseline writes a baseline file listing the stacks of the goroutines,
+// the most common first.
+func (gd *GoroutineDump) SaveBaseline(fn string) error {
+	groups := gd.GroupBy(dump.DedupModes["funcs"])
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i]) > len(groups[j])
+	})
+
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprint(w, baselineHeader)
+	for _, group := range groups {
+		sample := group[0]
+		if len(sample.Frames()) == 0 && sample.CreatedBy() == nil {
+			continue
+		}
+		fmt.Fprintf(w, "\n# %d goroutine(s), e.g. %s\n", len(group), strings.TrimSuffix(sample.Header(), ":"))
+		fmt.Fprintln(w, "stack")
+		for _, fr := range sample.Frames() {
+			fmt.Fprintf(w, "\t%s\n", fr.Func())
+		}
+		if fn := sample.CreatedByFunc(); fn != "" {
+			fmt.Fprintf(w, "\tcreated by %s\n", fn)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linuxerwang/goroutine-inspect/dump"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	fn := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(fn, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestSuppress(t *testing.T) {
+	newShell(t)
+	fn := writeFile(t, "baseline.txt", `# The main goroutine.
+cond id == 1
+
+stack
+	main.worker
+	created by main.main
+`)
+	gd := mustLoad(t, sampleDump+`
+goroutine 8 [select]:
+main.serve()
+	/src/main.go:30 +0x20
+created by main.main in goroutine 1
+	/src/main.go:13 +0x40
+`)
+	out := captureStdout(t, func() {
+		if err := gd.Suppress(fn); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if out != "Deleted 4 goroutines, kept 1.\n" {
+		t.Errorf("suppress printed %q", out)
+	}
+	if g := gd.Goroutines(); len(g) != 1 || g[0].ID() != 8 {
+		t.Errorf("kept %d goroutines, want goroutine 8", len(g))
+	}
+}
+
+func TestSaveBaseline(t *testing.T) {
+	newShell(t)
+	gd := mustLoad(t, sampleDump)
+	fn := filepath.Join(t.TempDir(), "baseline.txt")
+	if err := gd.SaveBaseline(fn); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := baselineHeader + `
+# 3 goroutine(s), e.g. goroutine 5 [chan receive, 12 minutes]
+stack
+	main.worker
+	created by main.main
+
+# 1 goroutine(s), e.g. goroutine 1 [running]
+stack
+	main.main
+`
+	if string(data) != want {
+		t.Errorf("baseline file:\n%s\nwant:\n%s", data, want)
+	}
+
+	// The stacks of a baseline match the goroutines it's generated from,
+	// as dedup() compares them.
+	bl, err := loadBaseline(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range gd.Goroutines() {
+		if !bl.stacks[dump.DedupModes["funcs"](g)] {
+			t.Errorf("goroutine %d isn't in the baseline", g.ID())
+		}
+	}
+	captureStdout(t, func() { gd.Suppress(fn) })
+	if n := len(gd.Goroutines()); n != 0 {
+		t.Errorf("kept %d goroutines, want none", n)
+	}
+}
+
+func TestLoadBaselineErrors(t *testing.T) {
+	for _, tc := range []struct {
+		content string
+		err     string
+	}{
+		{"stack\n\ncond id == 1\n", "baseline.txt:1: empty stack"},
+		{"# Comment.\nmain.worker\n", "baseline.txt:2: expect \"cond <condition>\" or \"stack\""},
+		{"cond id ==\n", "baseline.txt:1: Unexpected end of expression"},
+	} {
+		_, err := loadBaseline(writeFile(t, "baseline.txt", tc.content))
+		if err == nil || !strings.HasSuffix(err.Error(), tc.err) {
+			t.Errorf("loadBaseline(%q) error = %v, want %q", tc.content, err, tc.err)
+		}
+	}
+
+	bl, err := loadBaseline(writeFile(t, "baseline.txt", "stack\n\tmain.worker\n\tmain.main\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{dump.StackSignature([]string{"main.worker", "main.main"}, ""): true}; !reflect.DeepEqual(bl.stacks, want) {
+		t.Errorf("stacks = %v, want %v", bl.stacks, want)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/linuxerwang/goroutine-inspect/dump"
+)
+
+// chanFuncs take the channel (*hchan) as the first argument, when a goroutine
+// blocks on a channel. The runtime frames and their arguments are printed only
+// in the tracebacks of a crash or SIGQUIT with GOTRACEBACK=system or higher,
+// never by pprof or runtime.Stack. The first argument of runtime.selectgo is
+// the array of the select cases on the stack, not a channel, so the channels
+// of a select are never known.
+var chanFuncs = map[string]bool{
+	"runtime.chansend":  true,
+	"runtime.chansend1": true,
+	"runtime.chanrecv":  true,
+	"runtime.chanrecv1": true,
+	"runtime.chanrecv2": true,
+}
+
+// chanWait is a goroutine blocked on a channel operation.
+type chanWait struct {
+	g    *dump.Goroutine
+	op   string // "send", "receive" or "select".
+	addr string // The address of the channel, if known.
+	site string // Where the operation is, e.g. "main.produce (main.go:10)".
+	nil  bool   // Blocked forever on a nil channel or an empty select.
+
+	// runtime tells whether the stack has the runtime frames of the channel
+	// operation, which have the channel address.
+	runtime bool
+}
+
+// waitingChan tells the channel operation a goroutine is blocked on, if any.
+func waitingChan(g *dump.Goroutine) (*chanWait, bool) {
+	w := &chanWait{g: g}
+	switch {
+	case strings.HasPrefix(g.State(), "chan send"):
+		w.op = "send"
+	case strings.HasPrefix(g.State(), "chan receive"):
+		w.op = "receive"
+	case strings.HasPrefix(g.State(), "select"):
+		w.op = "select"
+	default:
+		return nil, false
+	}
+	w.nil = strings.Contains(g.State(), "(nil chan)") || strings.Contains(g.State(), "(no cases)")
+
+	for _, f := range g.Frames() {
+		if chanFuncs[f.Func()] || f.Func() == "runtime.selectgo" {
+			w.runtime = true
+		}
+		if chanFuncs[f.Func()] {
+			// The argument is inaccurate, e.g. "0x0?", when the runtime
+			// doesn't know it for sure, and is 0x0 for a nil channel.
+			if addr, ok := firstArgAddr(f); w.addr == "" && ok && !w.nil {
+				w.addr = addr
+			}
+			continue
+		}
+		if f.Package() != "runtime" {
+			w.site = fmt.Sprintf("%s (%s:%d)", f.Func(), f.File(), f.Line())
+			break
+		}
+	}
+	return w, true
+}
+
+// Channels prints the goroutines blocked on channels grouped by the channel
+// address, with the senders and receivers of each channel. Channels with
+// only one side waiting, which is how unbuffered channels leak goroutines,
+// are listed first. The goroutines blocked forever on nil channels, in select,
+// or without the channel addresses in the stacks are listed after them,
+// grouped by where they are blocked.
+func (gd *GoroutineDump) Channels() {
+	byAddr := map[string][]*chanWait{}
+	var addrs []string
+	var nils, selects, unknown []*chanWait
+	for _, g := range gd.Goroutines() {
+		w, ok := waitingChan(g)
+		if !ok {
+			continue
+		}
+		switch {
+		case w.nil:
+			nils = append(nils, w)
+			continue
+		case w.op == "select":
+			selects = append(selects, w)
+			continue
+		case w.addr == "":
+			unknown = append(unknown, w)
+			continue
+		}
+		if _, ok := byAddr[w.addr]; !ok {
+			addrs = append(addrs, w.addr)
+		}
+		byAddr[w.addr] = append(byAddr[w.addr], w)
+	}
+	if len(addrs) == 0 && len(nils) == 0 && len(selects) == 0 && len(unknown) == 0 {
+		fmt.Println("No goroutines blocked on channels.")
+		return
+	}
+
+	oneSided := func(addr string) bool {
+		ops := map[string]bool{}
+		for _, w := range byAddr[addr] {
+			ops[w.op] = true
+		}
+		return len(ops) == 1
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		if oneSided(addrs[i]) != oneSided(addrs[j]) {
+			return oneSided(addrs[i])
+		}
+		return len(byAddr[addrs[i]]) > len(byAddr[addrs[j]])
+	})
+
+	for _, addr := range addrs {
+		waits := byAddr[addr]
+		counts := map[string]int{}
+		for _, w := range waits {
+			counts[w.op]++
+		}
+		colorPrintf("[fg-green]Channel %s[reset]: %d sender(s), %d receiver(s)", addr, counts["send"], counts["receive"])
+		if oneSided(addr) {
+			side := "senders"
+			if waits[0].op == "receive" {
+				side = "receivers"
+			}
+			colorPrintf(", [fg-red]only %s waiting[reset]", side)
+		}
+		fmt.Println()
+		printChanWaits(waits)
+		fmt.Println()
+	}
+
+	if len(nils) > 0 {
+		colorPrintf("[fg-red]Nil channels[reset]: %d goroutine(s) blocked forever\n", len(nils))
+		printChanWaits(nils)
+		fmt.Println()
+	}
+	if len(selects) > 0 {
+		colorPrintf("[fg-yellow]In select[reset]: %d goroutine(s), the channels of a select aren't in the stacks\n", len(selects))
+		printChanWaits(selects)
+		fmt.Println()
+	}
+	if len(unknown) > 0 {
+		reason := "the addresses are inaccurate in the stacks"
+		for _, w := range unknown {
+			if !w.runtime {
+				reason = "the stacks have no runtime frames, dump by a crash or SIGQUIT with GOTRACEBACK=system to get them"
+				break
+			}
+		}
+		colorPrintf("[fg-yellow]Without channel addresses[reset]: %d goroutine(s), %s\n", len(unknown), reason)
+		printChanWaits(unknown)
+		fmt.Println()
+	}
+}
+
+// printChanWaits prints the ids of the goroutines blocked on channels,
+// grouped by the operation and where it is.
+func printChanWaits(waits []*chanWait) {
+	ids := map[string][]int{}
+	var keys []string
+	for _, w := range waits {
+		key := w.op + " at " + w.site
+		if _, ok := ids[key]; !ok {
+			keys = append(keys, key)
+		}
+		ids[key] = append(ids[key], w.g.ID())
+	}
+	for _, key := range keys {
+		fmt.Printf("  %s: %s\n", key, formatIds(ids[key]))
+	}
+}
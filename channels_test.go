@@ -0,0 +1,181 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// chanCrashDump is a part of a go1.27 panic traceback with GOTRACEBACK=system,
+// which has the runtime frames with the channel addresses: goroutines 6 and 7
+// send and goroutine 9 receives on unbuffered channels, goroutine 11 sends in
+// an inlined function, goroutine 13 is in select and goroutine 14 receives on a
+// nil channel.
+const chanCrashDump = `goroutine 6 gp=0x130505ee7680 m=nil [chan send]:
+runtime.gopark(0x0?, 0x0?, 0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/proc.go:474 +0xca fp=0x130505f1a708 sp=0x130505f1a6e8 pc=0x477dca
+runtime.chansend(0x130505f52070, 0x48a7b0, 0x1, 0x0?)
+	/usr/local/go/src/runtime/chan.go:283 +0x3fc fp=0x130505f1a778 sp=0x130505f1a708 pc=0x41223c
+runtime.chansend1(0x0?, 0x0?)
+	/usr/local/go/src/runtime/chan.go:161 +0x17 fp=0x130505f1a7a8 sp=0x130505f1a778 pc=0x411e37
+main.send(0x0?)
+	/tmp/ch/main.go:8 +0x1a fp=0x130505f1a7c8 sp=0x130505f1a7a8 pc=0x480ffa
+main.main.gowrap1()
+	/tmp/ch/main.go:27 +0x17 fp=0x130505f1a7e0 sp=0x130505f1a7c8 pc=0x481537
+runtime.goexit({})
+	/usr/local/go/src/runtime/asm_amd64.s:1264 +0x1 fp=0x130505f1a7e8 sp=0x130505f1a7e0 pc=0x47cfa1
+created by main.main in goroutine 1
+	/tmp/ch/main.go:27 +0x4b
+
+goroutine 7 gp=0x130505ee7860 m=nil [chan send]:
+runtime.gopark(0x0?, 0x0?, 0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/proc.go:474 +0xca fp=0x130505f1af08 sp=0x130505f1aee8 pc=0x477dca
+runtime.chansend(0x130505f52070, 0x48a7b0, 0x1, 0x0?)
+	/usr/local/go/src/runtime/chan.go:283 +0x3fc fp=0x130505f1af78 sp=0x130505f1af08 pc=0x41223c
+runtime.chansend1(0x0?, 0x0?)
+	/usr/local/go/src/runtime/chan.go:161 +0x17 fp=0x130505f1afa8 sp=0x130505f1af78 pc=0x411e37
+main.send(0x0?)
+	/tmp/ch/main.go:8 +0x1a fp=0x130505f1afc8 sp=0x130505f1afa8 pc=0x480ffa
+main.main.gowrap1()
+	/tmp/ch/main.go:27 +0x17 fp=0x130505f1afe0 sp=0x130505f1afc8 pc=0x481537
+runtime.goexit({})
+	/usr/local/go/src/runtime/asm_amd64.s:1264 +0x1 fp=0x130505f1afe8 sp=0x130505f1afe0 pc=0x47cfa1
+created by main.main in goroutine 1
+	/tmp/ch/main.go:27 +0x4b
+
+goroutine 9 gp=0x130505ee7c20 m=nil [chan receive]:
+runtime.gopark(0x0?, 0x0?, 0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/proc.go:474 +0xca fp=0x130505f1bf08 sp=0x130505f1bee8 pc=0x477dca
+runtime.chanrecv(0x130505f520e0, 0x0, 0x1)
+	/usr/local/go/src/runtime/chan.go:667 +0x4ae fp=0x130505f1bf80 sp=0x130505f1bf08 pc=0x41314e
+runtime.chanrecv1(0x0?, 0x0?)
+	/usr/local/go/src/runtime/chan.go:509 +0x12 fp=0x130505f1bfa8 sp=0x130505f1bf80 pc=0x412c92
+main.recv(0x0?)
+	/tmp/ch/main.go:11 +0x15 fp=0x130505f1bfc8 sp=0x130505f1bfa8 pc=0x481035
+main.main.gowrap2()
+	/tmp/ch/main.go:29 +0x17 fp=0x130505f1bfe0 sp=0x130505f1bfc8 pc=0x4814f7
+runtime.goexit({})
+	/usr/local/go/src/runtime/asm_amd64.s:1264 +0x1 fp=0x130505f1bfe8 sp=0x130505f1bfe0 pc=0x47cfa1
+created by main.main in goroutine 1
+	/tmp/ch/main.go:29 +0xdd
+
+goroutine 11 gp=0x130505f581e0 m=nil [chan send]:
+runtime.gopark(0x0?, 0x0?, 0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/proc.go:474 +0xca fp=0x130505f14f20 sp=0x130505f14f00 pc=0x477dca
+runtime.chansend(0x130505f52150, 0x48a7b0, 0x1, 0x0?)
+	/usr/local/go/src/runtime/chan.go:283 +0x3fc fp=0x130505f14f90 sp=0x130505f14f20 pc=0x41223c
+runtime.chansend1(0x0?, 0x0?)
+	/usr/local/go/src/runtime/chan.go:161 +0x17 fp=0x130505f14fc0 sp=0x130505f14f90 pc=0x411e37
+main.inlinedSend(...)
+	/tmp/ch/main.go:13
+main.main.gowrap4()
+	/tmp/ch/main.go:32 +0x1e fp=0x130505f14fe0 sp=0x130505f14fc0 pc=0x48147e
+runtime.goexit({})
+	/usr/local/go/src/runtime/asm_amd64.s:1264 +0x1 fp=0x130505f14fe8 sp=0x130505f14fe0 pc=0x47cfa1
+created by main.main in goroutine 1
+	/tmp/ch/main.go:32 +0x16a
+
+goroutine 13 gp=0x130505f585a0 m=nil [select]:
+runtime.gopark(0x130505f15f90?, 0x2?, 0x0?, 0x0?, 0x130505f15f84?)
+	/usr/local/go/src/runtime/proc.go:474 +0xca fp=0x130505f15e10 sp=0x130505f15df0 pc=0x477dca
+runtime.selectgo(0x130505f15f90, 0x130505f15f80, 0x0?, 0x1, 0x0?, 0x1)
+	/usr/local/go/src/runtime/select.go:351 +0xa97 fp=0x130505f15f50 sp=0x130505f15e10 pc=0x4578b7
+main.sel(0x0?, 0x0?)
+	/tmp/ch/main.go:17 +0x57 fp=0x130505f15fc0 sp=0x130505f15f50 pc=0x4810f7
+main.main.gowrap5()
+	/tmp/ch/main.go:35 +0x1b fp=0x130505f15fe0 sp=0x130505f15fc0 pc=0x4813fb
+runtime.goexit({})
+	/usr/local/go/src/runtime/asm_amd64.s:1264 +0x1 fp=0x130505f15fe8 sp=0x130505f15fe0 pc=0x47cfa1
+created by main.main in goroutine 1
+	/tmp/ch/main.go:35 +0x210
+
+goroutine 14 gp=0x130505f58780 m=nil [chan receive (nil chan)]:
+runtime.gopark(0x0?, 0x0?, 0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/proc.go:474 +0xca fp=0x130505f16720 sp=0x130505f16700 pc=0x477dca
+runtime.chanrecv(0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/chan.go:536 +0x1d9 fp=0x130505f16798 sp=0x130505f16720 pc=0x412e79
+runtime.chanrecv1(0x0?, 0x0?)
+	/usr/local/go/src/runtime/chan.go:509 +0x12 fp=0x130505f167c0 sp=0x130505f16798 pc=0x412c92
+main.main.func2()
+	/tmp/ch/main.go:36 +0x17 fp=0x130505f167e0 sp=0x130505f167c0 pc=0x481577
+runtime.goexit({})
+	/usr/local/go/src/runtime/asm_amd64.s:1264 +0x1 fp=0x130505f167e8 sp=0x130505f167e0 pc=0x47cfa1
+created by main.main in goroutine 1
+	/tmp/ch/main.go:36 +0x21c
+`
+
+// chanPprofDump is a part of the pprof goroutine profile with debug=2 of the
+// same program, which has no runtime frames.
+const chanPprofDump = `goroutine 7 [chan send]:
+main.send(0x0?)
+	/tmp/ch/main.go:10 +0x1a
+created by main.main in goroutine 1
+	/tmp/ch/main.go:29 +0x4b
+
+goroutine 10 [chan receive]:
+main.recv(0x0?)
+	/tmp/ch/main.go:13 +0x15
+created by main.main in goroutine 1
+	/tmp/ch/main.go:31 +0xe8
+
+goroutine 12 [chan send]:
+main.inlinedSend(...)
+	/tmp/ch/main.go:15
+created by main.main in goroutine 1
+	/tmp/ch/main.go:34 +0x187
+
+goroutine 14 [select]:
+main.sel(0x0?, 0x0?)
+	/tmp/ch/main.go:19 +0x57
+created by main.main in goroutine 1
+	/tmp/ch/main.go:37 +0x24b
+
+goroutine 15 [chan receive (nil chan)]:
+main.main.func2()
+	/tmp/ch/main.go:38 +0x17
+created by main.main in goroutine 1
+	/tmp/ch/main.go:38 +0x257
+`
+
+func TestChannels(t *testing.T) {
+	for _, tc := range []struct {
+		name, dump, want string
+	}{
+		{"crash", chanCrashDump, `Channel 0x130505f52070: 2 sender(s), 0 receiver(s), only senders waiting
+  send at main.send (/tmp/ch/main.go:8): 6, 7
+
+Channel 0x130505f520e0: 0 sender(s), 1 receiver(s), only receivers waiting
+  receive at main.recv (/tmp/ch/main.go:11): 9
+
+Channel 0x130505f52150: 1 sender(s), 0 receiver(s), only senders waiting
+  send at main.inlinedSend (/tmp/ch/main.go:13): 11
+
+Nil channels: 1 goroutine(s) blocked forever
+  receive at main.main.func2 (/tmp/ch/main.go:36): 14
+
+In select: 1 goroutine(s), the channels of a select aren't in the stacks
+  select at main.sel (/tmp/ch/main.go:17): 13
+
+`},
+		{"pprof", chanPprofDump, `Nil channels: 1 goroutine(s) blocked forever
+  receive at main.main.func2 (/tmp/ch/main.go:38): 15
+
+In select: 1 goroutine(s), the channels of a select aren't in the stacks
+  select at main.sel (/tmp/ch/main.go:19): 14
+
+Without channel addresses: 3 goroutine(s), the stacks have no runtime frames, dump by a crash or SIGQUIT with GOTRACEBACK=system to get them
+  send at main.send (/tmp/ch/main.go:10): 7
+  receive at main.recv (/tmp/ch/main.go:13): 10
+  send at main.inlinedSend (/tmp/ch/main.go:15): 12
+
+`},
+		{"none", sampleDump[:strings.Index(sampleDump, "\n\n")+1], "No goroutines blocked on channels.\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			plainSettings(t)
+			gd := mustLoad(t, tc.dump)
+			if out := captureStdout(t, gd.Channels); out != tc.want {
+				t.Errorf("channels() printed:\n%s\nwant:\n%s", out, tc.want)
+			}
+		})
+	}
+}
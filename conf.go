@@ -1,22 +1,213 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
-	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/BurntSushi/toml"
+	"github.com/linuxerwang/goroutine-inspect/dump"
 	"github.com/peterh/liner"
 )
 
+// Settings holds the settings of the interactive session. The defaults can
+// be changed in the config file, which is in TOML format:
+//
+//	show_limit = 20
+//	color = true
+//	compact = true
+//	own_modules = ["github.com/our"]
+//	hide_frames = ["google.golang.org/grpc/internal"]
+//	dedup_mode = "funcs"
+//
+//	[aliases]
+//	stuck = "x.search(\"duration > 30\")"
+//
+//	[path_rewrites]
+//	"/builds/ci/go/pkg/mod/" = "/home/me/go/pkg/mod/"
+//
+//	[source_paths]
+//	"/go/src/github.com/our/svc/" = "/home/me/svc/"
+type Settings struct {
+	// ShowLimit is the default limit of show() and search().
+	ShowLimit int `toml:"show_limit"`
+	// Color enables colored output.
+	Color bool `toml:"color"`
+	// Compact collapses runtime and standard library frames when
+	// displaying stack traces.
+	Compact bool `toml:"compact"`
+	// OwnModules are the package path prefixes of our own code. The first
+	// frame in our own code is highlighted. If empty, any package outside
+	// of the standard library is considered our own.
+	OwnModules []string `toml:"own_modules"`
+	// HideFrames are the function name prefixes of frames to collapse in
+	// compact mode, besides the standard library.
+	HideFrames []string `toml:"hide_frames"`
+	// DedupMode is the default mode of dedup().
+	DedupMode string `toml:"dedup_mode"`
+	// Aliases are the statement sequences defined by the alias command,
+	// separated by ";".
+	Aliases map[string]string `toml:"aliases"`
+	// PathRewrites maps the path prefixes of source files in stack traces to
+	// other ones when loading dumps, e.g. the paths on the build machines to
+	// local ones, so that dumps from different machines can be compared.
+	PathRewrites map[string]string `toml:"path_rewrites"`
+	// SourcePaths maps the path prefixes of source files where the binary
+	// was built to local ones, for source().
+	SourcePaths map[string]string `toml:"source_paths"`
+}
+
+var settings = Settings{
+	ShowLimit:    10,
+	Color:        true,
+	DedupMode:    "lines",
+	Aliases:      map[string]string{},
+	PathRewrites: map[string]string{},
+	SourcePaths:  map[string]string{},
+}
+
+// loadSettings loads the settings from the config file, if it exists.
+func loadSettings() error {
+	fn := getConfFile()
+	if _, err := os.Stat(fn); os.IsNotExist(err) {
+		return nil
+	}
+	s := settings
+	if _, err := toml.DecodeFile(fn, &s); err != nil {
+		return fmt.Errorf("invalid config file %s: %s", fn, err)
+	}
+	if s.ShowLimit <= 0 {
+		return fmt.Errorf("invalid config file %s: show_limit should be positive", fn)
+	}
+	if _, ok := dump.DedupModes[s.DedupMode]; !ok {
+		return fmt.Errorf("invalid config file %s: unknown dedup_mode %s", fn, s.DedupMode)
+	}
+	if s.Aliases == nil {
+		s.Aliases = map[string]string{}
+	}
+	if s.PathRewrites == nil {
+		s.PathRewrites = map[string]string{}
+	}
+	if s.SourcePaths == nil {
+		s.SourcePaths = map[string]string{}
+	}
+	settings = s
+	return nil
+}
+
+// persistSettings saves the settings of the session to the config file.
+func persistSettings() error {
+	f, err := os.Create(getConfFile())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return toml.NewEncoder(f).Encode(settings)
+}
+
+// saveAliases saves the aliases of the session to the config file, leaving
+// the other settings in the file as they are.
+func saveAliases() error {
+	fn := getConfFile()
+	conf := map[string]interface{}{}
+	if _, err := os.Stat(fn); err == nil {
+		if _, err := toml.DecodeFile(fn, &conf); err != nil {
+			return fmt.Errorf("invalid config file %s: %s", fn, err)
+		}
+	}
+	if len(settings.Aliases) > 0 {
+		conf["aliases"] = settings.Aliases
+	} else {
+		delete(conf, "aliases")
+	}
+
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return toml.NewEncoder(f).Encode(conf)
+}
+
+// set changes a setting for the session.
+func set(key, value string) error {
+	switch key {
+	case "color", "compact":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value %s for %s, expect true or false", value, key)
+		}
+		if key == "color" {
+			settings.Color = b
+		} else {
+			settings.Compact = b
+		}
+	case "dedup_mode":
+		if _, ok := dump.DedupModes[value]; !ok {
+			return fmt.Errorf("unknown dedup mode %s", value)
+		}
+		settings.DedupMode = value
+	case "hide_frames":
+		settings.HideFrames = splitList(value)
+	case "own_modules":
+		settings.OwnModules = splitList(value)
+	case "path_rewrites", "source_paths":
+		paths, err := parsePathMap(key, value)
+		if err != nil {
+			return err
+		}
+		if key == "path_rewrites" {
+			settings.PathRewrites = paths
+		} else {
+			settings.SourcePaths = paths
+		}
+	case "show_limit":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid value %s for show_limit, expect a positive integer", value)
+		}
+		settings.ShowLimit = n
+	default:
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	return nil
+}
+
+// splitList splits a comma separated list.
+func splitList(value string) []string {
+	var l []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// printSettings prints the settings of the session.
+func printSettings() {
+	fmt.Printf("%15s: %t\n", "color", settings.Color)
+	fmt.Printf("%15s: %t\n", "compact", settings.Compact)
+	fmt.Printf("%15s: %s\n", "dedup_mode", settings.DedupMode)
+	fmt.Printf("%15s: %s\n", "hide_frames", strings.Join(settings.HideFrames, ","))
+	fmt.Printf("%15s: %s\n", "own_modules", strings.Join(settings.OwnModules, ","))
+	fmt.Printf("%15s: %s\n", "path_rewrites", formatPathMap(settings.PathRewrites))
+	fmt.Printf("%15s: %d\n", "show_limit", settings.ShowLimit)
+	fmt.Printf("%15s: %s\n", "source_paths", formatPathMap(settings.SourcePaths))
+}
+
 func getConfDir() string {
-	usr, err := user.Current()
+	home, err := os.UserHomeDir()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	dir := filepath.Join(usr.HomeDir, ".goroutine-inspect")
+	dir := filepath.Join(home, ".goroutine-inspect")
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		if err = os.MkdirAll(dir, os.ModePerm); err != nil {
 			log.Fatal(err)
@@ -30,6 +221,10 @@ func getConfFile() string {
 	return filepath.Join(getConfDir(), "config")
 }
 
+func getMacrosFile() string {
+	return filepath.Join(getConfDir(), "macros")
+}
+
 func getHistoryFile() string {
 	return filepath.Join(getConfDir(), "history")
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConf(t *testing.T, dir, conf string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadSettings(t *testing.T) {
+	plainSettings(t)
+	dir := tempHome(t)
+	writeConf(t, dir, `show_limit = 20
+compact = true
+own_modules = ["github.com/our"]
+dedup_mode = "funcs"
+
+[aliases]
+stuck = "x.search(\"duration > 30\")"
+
+[path_rewrites]
+"/builds/ci/" = "/home/me/"
+`)
+
+	if err := loadSettings(); err != nil {
+		t.Fatal(err)
+	}
+	want := Settings{
+		ShowLimit:    20,
+		Compact:      true,
+		OwnModules:   []string{"github.com/our"},
+		DedupMode:    "funcs",
+		Aliases:      map[string]string{"stuck": `x.search("duration > 30")`},
+		PathRewrites: map[string]string{"/builds/ci/": "/home/me/"},
+		SourcePaths:  map[string]string{},
+	}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("settings = %+v, want %+v", settings, want)
+	}
+}
+
+func TestLoadSettingsErrors(t *testing.T) {
+	plainSettings(t)
+	dir := tempHome(t)
+	for _, tc := range []struct {
+		conf string
+		err  string
+	}{
+		{"show_limit = 0", "show_limit should be positive"},
+		{`dedup_mode = "stacks"`, "unknown dedup_mode stacks"},
+		{"color = 1", "invalid config file"},
+	} {
+		writeConf(t, dir, tc.conf)
+		err := loadSettings()
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("loadSettings() with %q error = %v, want %q", tc.conf, err, tc.err)
+		}
+		if settings.ShowLimit != 10 || settings.DedupMode != "lines" {
+			t.Errorf("loadSettings() with %q changed settings to %+v", tc.conf, settings)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	plainSettings(t)
+	for _, kv := range [][2]string{
+		{"color", "true"},
+		{"compact", "true"},
+		{"dedup_mode", "funcs"},
+		{"hide_frames", "google.golang.org/grpc/, net/http."},
+		{"own_modules", "github.com/our"},
+		{"path_rewrites", "/builds/=/home/me/"},
+		{"show_limit", "3"},
+		{"source_paths", "/go/src/=/home/me/src/,/opt/=/usr/"},
+	} {
+		if err := set(kv[0], kv[1]); err != nil {
+			t.Errorf("set(%s, %s) error: %s", kv[0], kv[1], err)
+		}
+	}
+	want := Settings{
+		ShowLimit:    3,
+		Color:        true,
+		Compact:      true,
+		OwnModules:   []string{"github.com/our"},
+		HideFrames:   []string{"google.golang.org/grpc/", "net/http."},
+		DedupMode:    "funcs",
+		Aliases:      map[string]string{},
+		PathRewrites: map[string]string{"/builds/": "/home/me/"},
+		SourcePaths:  map[string]string{"/go/src/": "/home/me/src/", "/opt/": "/usr/"},
+	}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("settings = %+v, want %+v", settings, want)
+	}
+
+	for _, kv := range [][2]string{
+		{"color", "yes"},
+		{"dedup_mode", "stacks"},
+		{"path_rewrites", "/builds/"},
+		{"show_limit", "0"},
+		{"theme", "dark"},
+	} {
+		if err := set(kv[0], kv[1]); err == nil {
+			t.Errorf("set(%s, %s) succeeded, want an error", kv[0], kv[1])
+		}
+	}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("settings changed by invalid values: %+v", settings)
+	}
+}
+
+func TestPersistSettings(t *testing.T) {
+	plainSettings(t)
+	tempHome(t)
+	set("show_limit", "3")
+	set("own_modules", "github.com/our")
+	settings.Aliases["stuck"] = `x.search("duration > 30")`
+	if err := persistSettings(); err != nil {
+		t.Fatal(err)
+	}
+	persisted := settings
+
+	plainSettings(t)
+	if err := loadSettings(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(settings, persisted) {
+		t.Errorf("loaded settings = %+v, want %+v", settings, persisted)
+	}
+}
+
+func TestSaveAliases(t *testing.T) {
+	plainSettings(t)
+	dir := tempHome(t)
+	writeConf(t, dir, "show_limit = 20\n")
+	if err := loadSettings(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Defining an alias saves it, but not the settings changed in the
+	// session.
+	set("show_limit", "3")
+	if err := defineAlias("stuck", `x.search("duration > 30")`); err != nil {
+		t.Fatal(err)
+	}
+	plainSettings(t)
+	if err := loadSettings(); err != nil {
+		t.Fatal(err)
+	}
+	if settings.ShowLimit != 20 || settings.Aliases["stuck"] != `x.search("duration > 30")` {
+		t.Errorf("settings = %+v, want show_limit 20 and alias stuck", settings)
+	}
+
+	if err := undefine("stuck"); err != nil {
+		t.Fatal(err)
+	}
+	plainSettings(t)
+	if err := loadSettings(); err != nil {
+		t.Fatal(err)
+	}
+	if settings.ShowLimit != 20 || len(settings.Aliases) != 0 {
+		t.Errorf("settings = %+v, want show_limit 20 and no aliases", settings)
+	}
+}
@@ -0,0 +1,214 @@
+package dump
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// contention is the delay measured by a block, mutex or CPU profile for a
+// call stack.
+type contention struct {
+	count int64 // The number of contentions or samples.
+	delay time.Duration
+}
+
+// parseContentions reads a pprof profile whose values include a duration in
+// nanoseconds, e.g. a block, mutex or CPU profile, and sums up the values by
+// the stack signature, see contentionSignature.
+func parseContentions(r io.Reader) (map[string]*contention, error) {
+	p, err := profile.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile: %s", err)
+	}
+
+	delayIdx, countIdx := -1, -1
+	for i, st := range p.SampleType {
+		switch st.Unit {
+		case "nanoseconds":
+			delayIdx = i
+		case "count":
+			countIdx = i
+		}
+	}
+	if delayIdx < 0 {
+		return nil, errors.New("profile has no values in nanoseconds, expect a block, mutex or CPU profile")
+	}
+
+	contentions := map[string]*contention{}
+	for _, s := range p.Sample {
+		var fns []string
+		for _, loc := range s.Location {
+			for _, l := range loc.Line {
+				if l.Function != nil {
+					fns = append(fns, l.Function.Name)
+				}
+			}
+		}
+		sig := contentionSignature(fns, "")
+		if sig == "" {
+			continue
+		}
+		c, ok := contentions[sig]
+		if !ok {
+			c = &contention{}
+			contentions[sig] = c
+		}
+		c.delay += time.Duration(s.Value[delayIdx])
+		if countIdx >= 0 {
+			c.count += s.Value[countIdx]
+		}
+	}
+	return contentions, nil
+}
+
+// blockingOps are the operations which goroutines block on, by the prefix
+// of the functions doing them. Frames of the same operation differ between
+// profiles and dumps: a block profile records sync.(*Mutex).Lock where the
+// goroutine parks in the runtime, a mutex profile records the
+// sync.(*Mutex).Unlock delaying the goroutines waiting in Lock, and the
+// runtime frames of channel operations are in dumps only with
+// GOTRACEBACK=system.
+var blockingOps = []struct{ prefix, op string }{
+	{"runtime.chansend", "chan send"},
+	{"runtime.chanrecv", "chan receive"},
+	{"runtime.selectgo", "select"},
+	{"runtime.block", "select"},
+	{"sync.(*Mutex).", "sync.Mutex"},
+	{"internal/sync.(*Mutex).", "sync.Mutex"},
+	{"sync.(*RWMutex).", "sync.RWMutex"},
+	{"sync.(*WaitGroup).", "sync.WaitGroup"},
+	{"sync.(*Cond).", "sync.Cond"},
+}
+
+// stateOps are the blocking operations of goroutines by the state, for the
+// ones without the frames of the operation in the stack.
+var stateOps = []struct{ prefix, op string }{
+	{"chan send", "chan send"},
+	{"chan receive", "chan receive"},
+	{"select", "select"},
+}
+
+// blockingOp returns the operation of the standard library frames above the
+// first frame outside it, innermost first: the known operation nearest to
+// that frame, else the frame nearest to it outside the runtime.
+func blockingOp(std []string) string {
+	for i := len(std) - 1; i >= 0; i-- {
+		for _, o := range blockingOps {
+			if strings.HasPrefix(std[i], o.prefix) {
+				return o.op
+			}
+		}
+	}
+	for i := len(std) - 1; i >= 0; i-- {
+		if FuncPackage(std[i]) != "runtime" {
+			return std[i]
+		}
+	}
+	return ""
+}
+
+// contentionSignature returns the signature of a call stack to match
+// goroutines with profiles: the blocking operation, see blockingOp, and the
+// function names outside the runtime from the first one outside the standard
+// library. The operation of a goroutine without its frames is told by the
+// state, which is "" for the stacks of profiles. Stacks all in the standard
+// library are matched by the function names outside the runtime.
+func contentionSignature(fns []string, state string) string {
+	i := 0
+	for i < len(fns) && IsStdPackage(FuncPackage(fns[i])) {
+		i++
+	}
+	var sig []string
+	if i < len(fns) {
+		op := blockingOp(fns[:i])
+		if op == "" {
+			for _, o := range stateOps {
+				if strings.HasPrefix(state, o.prefix) {
+					op = o.op
+					break
+				}
+			}
+		}
+		sig = append(sig, op)
+	} else {
+		i = 0
+	}
+	for _, fn := range fns[i:] {
+		if FuncPackage(fn) != "runtime" {
+			sig = append(sig, fn)
+		}
+	}
+	return strings.Join(sig, "\n")
+}
+
+// findContention returns the contention in the profile with the same stack
+// signature as the goroutine, see contentionSignature.
+func findContention(g *Goroutine, contentions map[string]*contention) *contention {
+	fns := make([]string, 0, len(g.frames))
+	for _, f := range g.frames {
+		fns = append(fns, f.fn)
+	}
+	return contentions[contentionSignature(fns, g.state)]
+}
+
+// share returns the part of total for the goroutines [from, to) of the n
+// sharing it. The parts are the differences of the rounded cumulative ones,
+// so that they add up to total. Computed in floats, as a delay in nanoseconds
+// times a count may overflow.
+func share(total int64, from, to, n int) int64 {
+	cum := func(k int) int64 { return int64(math.Round(float64(total) * float64(k) / float64(n))) }
+	return cum(to) - cum(from)
+}
+
+// Annotate attaches the delay measured by a block, mutex or CPU profile read
+// from r to the goroutines whose stacks match, so that they can be ranked by
+// delay rather than by count, e.g. Sort("delay desc"). The delay of a stack
+// is split across the goroutines matching it, by Count(). It returns the
+// number of goroutines annotated and the total delay of the stacks matched.
+func (gd *GoroutineDump) Annotate(r io.Reader) (int, time.Duration, error) {
+	contentions, err := parseContentions(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	found := make([]*contention, len(gd.goroutines))
+	matches := map[*contention]int{}
+	for i, g := range gd.goroutines {
+		if c := findContention(g, contentions); c != nil {
+			found[i] = c
+			matches[c] += g.Count()
+		}
+	}
+
+	annotated := 0
+	split := map[*contention]int{} // The count of goroutines given a share so far.
+	for i, g := range gd.goroutines {
+		c := found[i]
+		if c == nil && g.delay == 0 {
+			continue
+		}
+		// Replaced rather than modified, as goroutines are shared by copies
+		// of the dump.
+		ng := *g
+		ng.delay, ng.contentions = 0, 0
+		if c != nil {
+			from, to := split[c], split[c]+g.Count()
+			ng.delay = time.Duration(share(int64(c.delay), from, to, matches[c]))
+			ng.contentions = share(c.count, from, to, matches[c])
+			split[c] += g.Count()
+			annotated++
+		}
+		gd.goroutines[i] = &ng
+	}
+	var total time.Duration
+	for c := range matches {
+		total += c.delay
+	}
+	return annotated, total, nil
+}
@@ -0,0 +1,150 @@
+package dump
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+const lockDump = `goroutine 6 [sync.Mutex.Lock]:
+internal/sync.runtime_SemacquireMutex(0x0?, 0x1?, 0x2?)
+	/usr/local/go/src/runtime/sema.go:95 +0x25
+internal/sync.(*Mutex).lockSlow(0x60e5e0)
+	/usr/local/go/src/internal/sync/mutex.go:149 +0x15a
+internal/sync.(*Mutex).Lock(...)
+	/usr/local/go/src/internal/sync/mutex.go:70
+sync.(*Mutex).Lock(...)
+	/usr/local/go/src/sync/mutex.go:46
+main.worker()
+	/src/main.go:16 +0x35
+created by main.main in goroutine 1
+	/src/main.go:26 +0x45
+
+goroutine 7 [select]:
+main.serve()
+	/src/main.go:30 +0x20
+`
+
+// contentionProfile returns a profile with a sample of the delay for the
+// stack of functions, the innermost first.
+func contentionProfile(t *testing.T, delay time.Duration, fns ...string) []byte {
+	t.Helper()
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "contentions", Unit: "count"},
+			{Type: "delay", Unit: "nanoseconds"},
+		},
+		PeriodType: &profile.ValueType{Type: "contentions", Unit: "count"},
+		Period:     1,
+	}
+	s := &profile.Sample{Value: []int64{3, int64(delay)}}
+	for i, fn := range fns {
+		f := &profile.Function{ID: uint64(i + 1), Name: fn}
+		loc := &profile.Location{ID: uint64(i + 1), Line: []profile.Line{{Function: f}}}
+		p.Function = append(p.Function, f)
+		p.Location = append(p.Location, loc)
+		s.Location = append(s.Location, loc)
+	}
+	p.Sample = []*profile.Sample{s}
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAnnotate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		fns  []string
+	}{
+		{"block", []string{"sync.(*Mutex).Lock", "main.worker", "runtime.goexit"}},
+		{"mutex", []string{"sync.(*Mutex).Unlock", "main.worker", "runtime.goexit"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gd := mustParse(t, lockDump)
+			cp := gd.Copy()
+			n, total, err := gd.Annotate(bytes.NewReader(contentionProfile(t, 2*time.Second, tc.fns...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 || total != 2*time.Second {
+				t.Errorf("Annotate() = %d, %s, want 1, 2s", n, total)
+			}
+			if delay, count := gd.Goroutines()[0].Delay(); delay != 2*time.Second || count != 3 {
+				t.Errorf("goroutine 6 delayed %s in %d contentions, want 2s in 3", delay, count)
+			}
+			if delay, _ := cp.Goroutines()[0].Delay(); delay != 0 {
+				t.Errorf("goroutine 6 of the copy delayed %s", delay)
+			}
+		})
+	}
+
+	// The operation blocked on must match too.
+	gd := mustParse(t, lockDump)
+	n, _, err := gd.Annotate(bytes.NewReader(contentionProfile(t, 2*time.Second, "runtime.chanrecv1", "main.worker", "runtime.goexit")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("Annotate() with a chan receive annotated %d goroutines", n)
+	}
+
+	_, _, err = mustParse(t, lockDump).Annotate(strings.NewReader("not a profile"))
+	if err == nil {
+		t.Error("Annotate() succeeded with an invalid profile")
+	}
+}
+
+func TestAnnotateSplitsDelay(t *testing.T) {
+	// The dump has no runtime frames, so the operation is told by the state.
+	gd := mustParse(t, `goroutine 5 [chan receive]:
+main.worker()
+	/src/main.go:20 +0x30
+
+goroutine 6 [chan receive]:
+main.worker()
+	/src/main.go:20 +0x30
+
+goroutine 7 [chan receive]:
+main.worker()
+	/src/main.go:20 +0x30
+
+goroutine 8 [chan send]:
+main.worker()
+	/src/main.go:22 +0x30
+`)
+	if err := gd.Dedup("lines"); err != nil {
+		t.Fatal(err)
+	}
+	// Split 2 to 1 between the deduplicated goroutine and another one.
+	gd.Add(mustParse(t, "goroutine 9 [chan receive]:\nmain.worker()\n\t/src/main.go:21 +0x30\n").Goroutines()[0])
+
+	n, total, err := gd.Annotate(bytes.NewReader(contentionProfile(t, 4*time.Second, "runtime.chanrecv1", "main.worker", "runtime.goexit")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || total != 4*time.Second {
+		t.Errorf("Annotate() = %d, %s, want 2, 4s", n, total)
+	}
+	var delays []time.Duration
+	for _, g := range gd.Goroutines() {
+		delay, _ := g.Delay()
+		delays = append(delays, delay)
+	}
+	if want := []time.Duration{3 * time.Second, 0, time.Second}; !reflect.DeepEqual(delays, want) {
+		t.Errorf("delays = %v, want %v", delays, want)
+	}
+
+	// The delays add up when deduplicated again.
+	if err := gd.Dedup("funcs"); err != nil {
+		t.Fatal(err)
+	}
+	if delay, count := gd.Goroutines()[0].Delay(); delay != 4*time.Second || count != 3 {
+		t.Errorf("goroutine 5 delayed %s in %d contentions, want 4s in 3", delay, count)
+	}
+}
@@ -0,0 +1,301 @@
+package dump
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Knetic/govaluate"
+)
+
+var functions = map[string]govaluate.ExpressionFunction{
+	"contains": func(args ...interface{}) (interface{}, error) {
+		strs, err := stringArgs("contains", 2, args)
+		if err != nil {
+			return nil, err
+		}
+		return strings.Contains(strs[0], strs[1]), nil
+	},
+	"count": func(args ...interface{}) (interface{}, error) {
+		strs, err := stringArgs("count", 2, args)
+		if err != nil {
+			return nil, err
+		}
+		// Numbers are float64 in govaluate.
+		return float64(strings.Count(strs[0], strs[1])), nil
+	},
+	"endswith": func(args ...interface{}) (interface{}, error) {
+		strs, err := stringArgs("endswith", 2, args)
+		if err != nil {
+			return nil, err
+		}
+		return strings.HasSuffix(strs[0], strs[1]), nil
+	},
+	"lower": func(args ...interface{}) (interface{}, error) {
+		strs, err := stringArgs("lower", 1, args)
+		if err != nil {
+			return nil, err
+		}
+		return strings.ToLower(strs[0]), nil
+	},
+	"startswith": func(args ...interface{}) (interface{}, error) {
+		strs, err := stringArgs("startswith", 2, args)
+		if err != nil {
+			return nil, err
+		}
+		return strings.HasPrefix(strs[0], strs[1]), nil
+	},
+	"upper": func(args ...interface{}) (interface{}, error) {
+		strs, err := stringArgs("upper", 1, args)
+		if err != nil {
+			return nil, err
+		}
+		return strings.ToUpper(strs[0]), nil
+	},
+}
+
+// Condition is a compiled condition over the properties of a goroutine, e.g.
+// "state == 'chan receive' && duration > 10", see IsProperty(). It's not
+// safe for concurrent use.
+type Condition struct {
+	expr    *govaluate.EvaluableExpression
+	macros  []macroExpr
+	current *Goroutine // The goroutine being matched.
+}
+
+// macroExpr is a compiled named condition.
+type macroExpr struct {
+	name string
+	expr *govaluate.EvaluableExpression
+}
+
+// CompileCondition compiles a condition. The condition may reference named
+// conditions in macros by their names, which in turn may reference others.
+func CompileCondition(cond string, macros map[string]string) (*Condition, error) {
+	c := &Condition{}
+	fns := c.functions()
+	expr, err := govaluate.NewEvaluableExpressionWithFunctions(cond, fns)
+	if err != nil {
+		return nil, err
+	}
+	c.expr = expr
+	if c.macros, err = compileMacros(expr.Vars(), macros, fns); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Match tells if the goroutine matches the condition.
+func (c *Condition) Match(g *Goroutine) (bool, error) {
+	c.current = g
+	params := g.params()
+	for _, m := range c.macros {
+		v, err := m.expr.Evaluate(params)
+		if err != nil {
+			return false, err
+		}
+		params[m.name] = v
+	}
+	res, err := c.expr.Evaluate(params)
+	if err != nil {
+		return false, err
+	}
+	val, ok := res.(bool)
+	if !ok {
+		return false, errors.New("argument expression should return a boolean")
+	}
+	return val, nil
+}
+
+// functions returns the functions for evaluating the condition: functions,
+// plus the ones evaluated against the goroutine being matched, plus the ones
+// caching state across the goroutines.
+func (c *Condition) functions() map[string]govaluate.ExpressionFunction {
+	// Compiled patterns are cached so that they're not compiled once per
+	// goroutine.
+	regexps := map[string]*regexp.Regexp{}
+	compile := func(expr string) (*regexp.Regexp, error) {
+		if re, ok := regexps[expr]; ok {
+			return re, nil
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		regexps[expr] = re
+		return re, nil
+	}
+
+	fns := map[string]govaluate.ExpressionFunction{
+		"glob": func(args ...interface{}) (interface{}, error) {
+			strs, err := stringArgs("glob", 2, args)
+			if err != nil {
+				return nil, err
+			}
+			re, err := compile(globToRegexp(strs[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob pattern %q", strs[1])
+			}
+			return re.MatchString(strs[0]), nil
+		},
+		"frame": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("frame() accepts exactly one argument")
+			}
+			n, ok := args[0].(float64)
+			if !ok {
+				return nil, fmt.Errorf("frame() expects a number argument, got %v", args[0])
+			}
+			if frames := c.current.frames; n >= 0 && int(n) < len(frames) {
+				return frames[int(n)].fn, nil
+			}
+			return "", nil
+		},
+		"has_frame": func(args ...interface{}) (interface{}, error) {
+			strs, err := stringArgs("has_frame", 1, args)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range c.current.frames {
+				if f.fn == strs[0] {
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+		"in_package": func(args ...interface{}) (interface{}, error) {
+			strs, err := stringArgs("in_package", 1, args)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range c.current.frames {
+				if InPackage(f.pkg, strs[0]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+		"label": func(args ...interface{}) (interface{}, error) {
+			strs, err := stringArgs("label", 1, args)
+			if err != nil {
+				return nil, err
+			}
+			return c.current.labels[strs[0]], nil
+		},
+		"match": func(args ...interface{}) (interface{}, error) {
+			strs, err := stringArgs("match", 2, args)
+			if err != nil {
+				return nil, err
+			}
+			re, err := compile(strs[1])
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(strs[0]), nil
+		},
+	}
+	for k, f := range functions {
+		fns[k] = f
+	}
+	return fns
+}
+
+// compileMacros compiles the named conditions referenced by vars, including
+// the ones referenced by them, in the order to be evaluated.
+func compileMacros(vars []string, macros map[string]string, fns map[string]govaluate.ExpressionFunction) ([]macroExpr, error) {
+	var compiled []macroExpr
+	visited := map[string]bool{}
+	var path []string // The named conditions being visited.
+
+	var visit func(string) error
+	visit = func(name string) error {
+		cond, ok := macros[name]
+		if !ok {
+			if IsProperty(name) {
+				return nil
+			}
+			return fmt.Errorf("%s is neither a goroutine property nor a named condition", name)
+		}
+		if visited[name] {
+			return nil
+		}
+		for i, n := range path {
+			if n == name {
+				if i == len(path)-1 {
+					return fmt.Errorf("named condition %s references itself", name)
+				}
+				return fmt.Errorf("named conditions reference each other: %s -> %s", strings.Join(path[i:], " -> "), name)
+			}
+		}
+		path = append(path, name)
+		defer func() { path = path[:len(path)-1] }()
+		expr, err := govaluate.NewEvaluableExpressionWithFunctions(cond, fns)
+		if err != nil {
+			return fmt.Errorf("invalid named condition %s: %s", name, err)
+		}
+		for _, v := range expr.Vars() {
+			if err := visit(v); err != nil {
+				return err
+			}
+		}
+		visited[name] = true
+		compiled = append(compiled, macroExpr{name: name, expr: expr})
+		return nil
+	}
+
+	for _, v := range vars {
+		if err := visit(v); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
+
+// stringArgs checks that args are exactly n strings and returns them.
+func stringArgs(fn string, n int, args []interface{}) ([]string, error) {
+	if len(args) != n {
+		if n == 1 {
+			return nil, fmt.Errorf("%s() accepts exactly one argument", fn)
+		}
+		return nil, fmt.Errorf("%s() accepts exactly %d arguments", fn, n)
+	}
+	strs := make([]string, n)
+	for i, arg := range args {
+		s, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s() expects string arguments, got %v", fn, arg)
+		}
+		strs[i] = s
+	}
+	return strs, nil
+}
+
+// globToRegexp converts a glob pattern to an anchored regex. Unlike
+// path.Match, "*" also matches "/", so that "net/http.*ServeHTTP" matches
+// function names in sub-packages too.
+func globToRegexp(glob string) string {
+	var buf strings.Builder
+	buf.WriteString("(?s)^")
+	inClass := false
+	for _, r := range glob {
+		switch {
+		case inClass:
+			if r == ']' {
+				inClass = false
+			}
+			buf.WriteRune(r)
+		case r == '*':
+			buf.WriteString(".*")
+		case r == '?':
+			buf.WriteString(".")
+		case r == '[':
+			inClass = true
+			buf.WriteRune(r)
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buf.WriteString("$")
+	return buf.String()
+}
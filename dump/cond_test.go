@@ -0,0 +1,81 @@
+package dump
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustCompile(t *testing.T, cond string, macros map[string]string) *Condition {
+	t.Helper()
+	c, err := CompileCondition(cond, macros)
+	if err != nil {
+		t.Fatalf("CompileCondition(%q) error: %s", cond, err)
+	}
+	return c
+}
+
+func TestCondition(t *testing.T) {
+	gd := mustParse(t, sampleDump+`
+goroutine 9 [select] {handler: api}:
+net/http.(*conn).serve(0xc000120000)
+	/usr/local/go/src/net/http/server.go:2000 +0x10
+`)
+	macros := map[string]string{
+		"waiting": "state == 'chan receive'",
+		"stuck":   "waiting && duration > 10",
+	}
+	for _, tc := range []struct {
+		cond string
+		want []int
+	}{
+		{"true", []int{1, 5, 6, 7, 9}},
+		{"stuck", []int{5, 6}},
+		{"waiting && !stuck", []int{7}},
+		{"has_frame('main.worker') && frame(0) == 'main.worker'", []int{5, 6, 7}},
+		{"in_package('net')", []int{9}},
+		{"label('handler') == 'api'", []int{9}},
+		{"glob(top, 'main.*')", []int{1, 5, 6, 7}},
+		{"match(created_by, '^main\\\\.')", []int{5, 6, 7}},
+		{"contains(trace, 'main.go:21')", []int{7}},
+		{"depth == 1 && lines == 5", []int{5, 6, 7}},
+	} {
+		matched, err := gd.Filter(mustCompile(t, tc.cond, macros))
+		if err != nil {
+			t.Errorf("Filter(%q) error: %s", tc.cond, err)
+			continue
+		}
+		if got := ids(matched); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Filter(%q) = %v, want %v", tc.cond, got, tc.want)
+		}
+	}
+}
+
+func TestConditionErrors(t *testing.T) {
+	macros := map[string]string{
+		"loop":  "loop",
+		"ping":  "pong",
+		"pong":  "ping && id > 1",
+		"stuck": "duration >",
+	}
+	for _, tc := range []struct {
+		cond string
+		err  string
+	}{
+		{"state ==", "Unexpected end of expression"},
+		{"loop", "named condition loop references itself"},
+		{"ping", "named conditions reference each other: ping -> pong -> ping"},
+		{"stuck", "invalid named condition stuck"},
+		{"stat == 'running'", "stat is neither a goroutine property nor a named condition"},
+	} {
+		_, err := CompileCondition(tc.cond, macros)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("CompileCondition(%q) error = %v, want %q", tc.cond, err, tc.err)
+		}
+	}
+
+	c := mustCompile(t, "state", nil)
+	if _, err := c.Match(mustParse(t, sampleDump).Goroutines()[0]); err == nil {
+		t.Error("Match() succeeded with a non-boolean condition")
+	}
+}
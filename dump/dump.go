@@ -0,0 +1,319 @@
+// Package dump parses Go goroutine dumps and goroutine profiles, and filters,
+// deduplicates and compares the goroutines in them.
+//
+// A dump is parsed from the output of a panic, SIGQUIT or
+// runtime/pprof.Lookup("goroutine").WriteTo(w, 2), or a goroutine profile
+// written with debug=1 or in the protobuf format:
+//
+//	gd, err := dump.Parse(f)
+//	cond, err := dump.CompileCondition("state == 'chan receive' && duration > 10", nil)
+//	stuck, err := gd.Filter(cond)
+//	stuck.Dedup("funcs")
+//	stuck.Write(os.Stdout)
+//
+// Nothing is printed by the package, results are returned or written to the
+// writers given.
+package dump
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GoroutineDump defines a goroutine dump.
+type GoroutineDump struct {
+	goroutines []*Goroutine
+	buildID    string // The build ID of the binary producing the dump, if known.
+}
+
+// New creates a dump of the goroutines.
+func New(goroutines ...*Goroutine) *GoroutineDump {
+	return &GoroutineDump{
+		goroutines: append([]*Goroutine{}, goroutines...),
+	}
+}
+
+// newFromMap creates a dump of the goroutines in a map.
+func newFromMap(gs map[int]*Goroutine) *GoroutineDump {
+	gd := New()
+	for _, v := range gs {
+		gd.goroutines = append(gd.goroutines, v)
+	}
+	return gd
+}
+
+// Goroutines returns the goroutines in the dump. The slice shouldn't be
+// modified.
+func (gd *GoroutineDump) Goroutines() []*Goroutine {
+	return gd.goroutines
+}
+
+// Add appends a goroutine info to the list.
+func (gd *GoroutineDump) Add(g *Goroutine) {
+	gd.goroutines = append(gd.goroutines, g)
+}
+
+// Copy returns a copy of the dump. The goroutines are shared, so are not to
+// be modified.
+func (gd *GoroutineDump) Copy() *GoroutineDump {
+	c := New(gd.goroutines...)
+	c.buildID = gd.buildID
+	return c
+}
+
+// BuildID returns the GNU build ID of the binary producing the dump in hex,
+// which is recorded only in goroutine profiles in the protobuf format, or "".
+func (gd *GoroutineDump) BuildID() string {
+	return gd.buildID
+}
+
+// Filter returns a dump of the goroutines matching the condition.
+func (gd *GoroutineDump) Filter(cond *Condition) (*GoroutineDump, error) {
+	goroutines, err := gd.filter(cond, true)
+	if err != nil {
+		return nil, err
+	}
+	return New(goroutines...), nil
+}
+
+// Keep keeps the goroutines matching the condition.
+func (gd *GoroutineDump) Keep(cond *Condition) error {
+	goroutines, err := gd.filter(cond, true)
+	if err != nil {
+		return err
+	}
+	gd.goroutines = goroutines
+	return nil
+}
+
+// Delete deletes the goroutines matching the condition.
+func (gd *GoroutineDump) Delete(cond *Condition) error {
+	goroutines, err := gd.filter(cond, false)
+	if err != nil {
+		return err
+	}
+	gd.goroutines = goroutines
+	return nil
+}
+
+// filter returns the goroutines for which the condition evaluates to want.
+func (gd *GoroutineDump) filter(cond *Condition, want bool) ([]*Goroutine, error) {
+	goroutines := make([]*Goroutine, 0, len(gd.goroutines))
+	for _, g := range gd.goroutines {
+		matched, err := cond.Match(g)
+		if err != nil {
+			return nil, err
+		}
+		if matched == want {
+			goroutines = append(goroutines, g)
+		}
+	}
+	return goroutines, nil
+}
+
+// Dedup finds goroutines with duplicated stack traces and keeps only one copy
+// of them, with the ids of all in Duplicates(). The mode decides how stack
+// traces are compared, see DedupModes.
+func (gd *GoroutineDump) Dedup(mode string) error {
+	signature, ok := DedupModes[mode]
+	if !ok {
+		return fmt.Errorf("unknown dedup mode %s", mode)
+	}
+
+	groups := gd.GroupBy(signature)
+	kept := make([]*Goroutine, 0, len(groups))
+	for _, group := range groups {
+		// The ids of goroutines kept by an earlier dedup include the ones
+		// dedupped into them.
+		ids := make([]int, 0, len(group))
+		var delay time.Duration
+		var contentions int64
+		for _, g := range group {
+			if len(g.duplicates) > 0 {
+				ids = append(ids, g.duplicates...)
+			} else {
+				ids = append(ids, g.id)
+			}
+			// The delays attached by Annotate() are split across the
+			// goroutines, so they add up.
+			delay, contentions = delay+g.delay, contentions+g.contentions
+		}
+		// Replaced rather than modified, as goroutines are shared by copies
+		// of the dump.
+		ng := *group[0]
+		ng.duplicates = ids
+		ng.delay, ng.contentions = delay, contentions
+		kept = append(kept, &ng)
+	}
+	gd.goroutines = kept
+	return nil
+}
+
+// GroupBy groups the goroutines with the same signature, in the order of the
+// first goroutine of each group.
+func (gd *GoroutineDump) GroupBy(signature func(*Goroutine) string) [][]*Goroutine {
+	index := map[string]int{}
+	var groups [][]*Goroutine
+	for _, g := range gd.goroutines {
+		sig := signature(g)
+		if i, ok := index[sig]; ok {
+			groups[i] = append(groups[i], g)
+			continue
+		}
+		index[sig] = len(groups)
+		groups = append(groups, []*Goroutine{g})
+	}
+	return groups
+}
+
+// Diff returns the goroutines only in gd, in both, and only in another, by
+// goroutine id.
+func (gd *GoroutineDump) Diff(another *GoroutineDump) (*GoroutineDump, *GoroutineDump, *GoroutineDump) {
+	lonly := map[int]*Goroutine{}
+	ronly := map[int]*Goroutine{}
+	common := map[int]*Goroutine{}
+
+	for _, v := range gd.goroutines {
+		lonly[v.id] = v
+	}
+	for _, v := range another.goroutines {
+		if _, ok := lonly[v.id]; ok {
+			delete(lonly, v.id)
+			common[v.id] = v
+		} else {
+			ronly[v.id] = v
+		}
+	}
+	return newFromMap(lonly), newFromMap(common), newFromMap(ronly)
+}
+
+// matchKey returns how goroutines of different dumps are matched in set
+// operations: "id" matches by goroutine id, while a dedup mode ("lines" or
+// "funcs") matches by stack signature.
+func matchKey(key string) (func(*Goroutine) string, error) {
+	if key == "id" {
+		return func(g *Goroutine) string {
+			return strconv.Itoa(g.id)
+		}, nil
+	}
+	if signature, ok := DedupModes[key]; ok {
+		return signature, nil
+	}
+	return nil, fmt.Errorf("unknown matching key %s", key)
+}
+
+// Union returns the goroutines in either dump. Goroutines of another matching
+// one in gd are left out.
+func (gd *GoroutineDump) Union(another *GoroutineDump, key string) (*GoroutineDump, error) {
+	rest, err := another.match(gd, key, false)
+	if err != nil {
+		return nil, err
+	}
+	return New(append(append([]*Goroutine{}, gd.goroutines...), rest.goroutines...)...), nil
+}
+
+// Intersect returns the goroutines in gd matching one in another.
+func (gd *GoroutineDump) Intersect(another *GoroutineDump, key string) (*GoroutineDump, error) {
+	return gd.match(another, key, true)
+}
+
+// Subtract returns the goroutines in gd matching none in another.
+func (gd *GoroutineDump) Subtract(another *GoroutineDump, key string) (*GoroutineDump, error) {
+	return gd.match(another, key, false)
+}
+
+// match returns the goroutines in gd which match one in another if matched is
+// true, or which match none otherwise.
+func (gd *GoroutineDump) match(another *GoroutineDump, key string, matched bool) (*GoroutineDump, error) {
+	keyOf, err := matchKey(key)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]bool{}
+	for _, g := range another.goroutines {
+		keys[keyOf(g)] = true
+	}
+	dump := New()
+	for _, g := range gd.goroutines {
+		if keys[keyOf(g)] == matched {
+			dump.Add(g)
+		}
+	}
+	return dump, nil
+}
+
+// Sort sorts the goroutine entries by a property, optionally followed by
+// "asc" or "desc", e.g. "dups desc". Goroutines with equal values keep their
+// order.
+func (gd *GoroutineDump) Sort(spec string) error {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return fmt.Errorf("invalid sort order %q", spec)
+	}
+	key := fields[0]
+	if !IsProperty(key) {
+		return fmt.Errorf("unknown property %s", key)
+	}
+	desc := false
+	if len(fields) == 2 {
+		switch strings.ToLower(fields[1]) {
+		case "asc":
+		case "desc":
+			desc = true
+		default:
+			return fmt.Errorf("invalid sort order %q", spec)
+		}
+	}
+
+	values := make(map[*Goroutine]interface{}, len(gd.goroutines))
+	for _, g := range gd.goroutines {
+		values[g] = g.params()[key]
+	}
+	sort.SliceStable(gd.goroutines, func(i, j int) bool {
+		a, b := values[gd.goroutines[i]], values[gd.goroutines[j]]
+		if desc {
+			a, b = b, a
+		}
+		switch a := a.(type) {
+		case int:
+			return a < b.(int)
+		case string:
+			return a < b.(string)
+		case bool:
+			return !a && b.(bool)
+		}
+		return false
+	})
+	return nil
+}
+
+// Stats returns the number of goroutines in each state and with each header
+// flag, including "locked".
+func (gd *GoroutineDump) Stats() (map[string]int, map[string]int) {
+	stats := map[string]int{}
+	flagStats := map[string]int{}
+	for _, g := range gd.goroutines {
+		stats[g.state]++
+		if g.locked {
+			flagStats["locked"]++
+		}
+		for _, f := range g.flags {
+			flagStats[f]++
+		}
+	}
+	return stats, flagStats
+}
+
+// Write writes the goroutines to w, in the format of a goroutine dump.
+func (gd *GoroutineDump) Write(w io.Writer) error {
+	for _, g := range gd.goroutines {
+		if err := g.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
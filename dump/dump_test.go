@@ -0,0 +1,158 @@
+package dump
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDedup(t *testing.T) {
+	for _, tc := range []struct {
+		mode string
+		ids  []int
+		dups [][]int
+	}{
+		{"lines", []int{1, 5, 7}, [][]int{{1}, {5, 6}, {7}}},
+		{"funcs", []int{1, 5}, [][]int{{1}, {5, 6, 7}}},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			gd := mustParse(t, sampleDump)
+			if err := gd.Dedup(tc.mode); err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(gd); !reflect.DeepEqual(got, tc.ids) {
+				t.Errorf("ids = %v, want %v", got, tc.ids)
+			}
+			var dups [][]int
+			for _, g := range gd.Goroutines() {
+				dups = append(dups, g.Duplicates())
+			}
+			if !reflect.DeepEqual(dups, tc.dups) {
+				t.Errorf("duplicates = %v, want %v", dups, tc.dups)
+			}
+		})
+	}
+
+	if err := mustParse(t, sampleDump).Dedup("stacks"); err == nil {
+		t.Error("Dedup() succeeded with an unknown mode")
+	}
+}
+
+func TestDedupAgain(t *testing.T) {
+	gd := mustParse(t, sampleDump)
+	if err := gd.Dedup("lines"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gd.Dedup("funcs"); err != nil {
+		t.Fatal(err)
+	}
+	if dups := gd.Goroutines()[1].Duplicates(); !reflect.DeepEqual(dups, []int{5, 6, 7}) {
+		t.Errorf("duplicates = %v, want [5 6 7]", dups)
+	}
+	if n := gd.Goroutines()[1].Count(); n != 3 {
+		t.Errorf("Count() = %d, want 3", n)
+	}
+}
+
+func TestDedupKeepsCopies(t *testing.T) {
+	gd := mustParse(t, sampleDump)
+	cp := gd.Copy()
+	if err := cp.Dedup("lines"); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range gd.Goroutines() {
+		if dups := g.Duplicates(); len(dups) > 0 || g.Count() != 1 {
+			t.Errorf("goroutine %d of the original has duplicates %v", g.ID(), dups)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := mustParse(t, sampleDump)
+	b := mustParse(t, strings.Replace(sampleDump, "goroutine 1 [running]", "goroutine 2 [running]", 1))
+	b.Delete(mustCompile(t, "id == 7", nil))
+
+	lonly, common, ronly := a.Diff(b)
+	for _, tc := range []struct {
+		name string
+		gd   *GoroutineDump
+		want []int
+	}{
+		{"left only", lonly, []int{1, 7}},
+		{"common", common, []int{5, 6}},
+		{"right only", ronly, []int{2}},
+	} {
+		if got := sortedIds(tc.gd); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	a := mustParse(t, sampleDump)
+	// Goroutines 5 and 6 are gone, and goroutine 8 is at the same line as
+	// them.
+	b := mustParse(t, strings.Replace(sampleDump, "goroutine 5 ", "goroutine 8 ", 1))
+	b.Delete(mustCompile(t, "id == 6", nil))
+
+	for _, tc := range []struct {
+		op   string
+		key  string
+		want []int
+	}{
+		{"union", "id", []int{1, 5, 6, 7, 8}},
+		{"union", "lines", []int{1, 5, 6, 7}},
+		{"intersect", "id", []int{1, 7}},
+		{"intersect", "lines", []int{1, 5, 6, 7}},
+		{"intersect", "funcs", []int{1, 5, 6, 7}},
+		{"subtract", "id", []int{5, 6}},
+		{"subtract", "lines", []int{}},
+	} {
+		var (
+			gd  *GoroutineDump
+			err error
+		)
+		switch tc.op {
+		case "union":
+			gd, err = a.Union(b, tc.key)
+		case "intersect":
+			gd, err = a.Intersect(b, tc.key)
+		case "subtract":
+			gd, err = a.Subtract(b, tc.key)
+		}
+		if err != nil {
+			t.Errorf("%s by %s: %s", tc.op, tc.key, err)
+			continue
+		}
+		if got := ids(gd); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s by %s = %v, want %v", tc.op, tc.key, got, tc.want)
+		}
+	}
+
+	if _, err := a.Union(b, "name"); err == nil {
+		t.Error("Union() succeeded with an unknown key")
+	}
+}
+
+func TestSort(t *testing.T) {
+	gd := mustParse(t, sampleDump)
+	if err := gd.Sort("duration desc"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(gd), []int{5, 6, 7, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ids = %v, want %v", got, want)
+	}
+	for _, spec := range []string{"", "name", "id up"} {
+		if err := gd.Sort(spec); err == nil {
+			t.Errorf("Sort(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+// sortedIds returns the goroutine ids in ascending order, for the dumps
+// without order.
+func sortedIds(gd *GoroutineDump) []int {
+	cp := gd.Copy()
+	cp.Sort("id")
+	return ids(cp)
+}
@@ -0,0 +1,109 @@
+package dump
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Frame is a function call in a goroutine stack trace.
+type Frame struct {
+	fn   string // The function name, e.g. net/http.(*Server).Serve.
+	pkg  string // The package path, e.g. net/http.
+	args string // The argument words, e.g. "0xc420e59ce0, 0x1".
+	file string
+	line int
+}
+
+// Func returns the function name, e.g. net/http.(*Server).Serve.
+func (f *Frame) Func() string { return f.fn }
+
+// Package returns the package path of the function, e.g. net/http.
+func (f *Frame) Package() string { return f.pkg }
+
+// Args returns the argument words, e.g. "0xc420e59ce0, 0x1".
+func (f *Frame) Args() string { return f.args }
+
+// File returns the path of the source file, if any.
+func (f *Frame) File() string { return f.file }
+
+// Line returns the line number in the source file, if any.
+func (f *Frame) Line() int { return f.line }
+
+// parseFuncLine parses a function line of a stack trace, e.g.:
+//
+//	net/http.(*Server).Serve(0xc4200b4000, 0xe9a080, 0xc4216f0088)
+func parseFuncLine(l string) *Frame {
+	l = strings.TrimSpace(l)
+	if !strings.HasSuffix(l, ")") {
+		return &Frame{fn: l, pkg: FuncPackage(l)}
+	}
+	// Find the parenthesis matching the last one, as the function name may
+	// contain parentheses too, e.g. (*T).M.
+	depth := 0
+	for i := len(l) - 1; i >= 0; i-- {
+		switch l[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+			if depth == 0 {
+				return &Frame{fn: l[:i], pkg: FuncPackage(l[:i]), args: l[i+1 : len(l)-1]}
+			}
+		}
+	}
+	return &Frame{fn: l, pkg: FuncPackage(l)}
+}
+
+// FuncPackage returns the package path of a function name, e.g.:
+//
+//	github.com/a/b.(*T[...]).M                  => github.com/a/b
+//	github.com/a/b.F[github.com/c/d.T]          => github.com/a/b
+//	gopkg.in/yaml%2ev2.(*decoder).unmarshal     => gopkg.in/yaml.v2
+func FuncPackage(fn string) string {
+	// Type parameters and receivers may contain dots and slashes too.
+	if idx := strings.IndexAny(fn, "(["); idx >= 0 {
+		fn = fn[:idx]
+	}
+	slash := strings.LastIndex(fn, "/")
+	dot := strings.Index(fn[slash+1:], ".")
+	if dot < 0 {
+		return ""
+	}
+	// The linker escapes dots in the last path element.
+	return strings.Replace(fn[:slash+1+dot], "%2e", ".", -1)
+}
+
+// InPackage tells if pkg is the package path or under it.
+func InPackage(pkg, path string) bool {
+	return pkg == path || strings.HasPrefix(pkg, strings.TrimSuffix(path, "/")+"/")
+}
+
+// parseFileLine parses a file line of a stack trace into f, e.g.:
+//
+//	/usr/local/go/src/net/http/server.go:2933 +0x1f4
+func (f *Frame) parseFileLine(l string) {
+	l = strings.TrimSpace(l)
+	if idx := strings.LastIndex(l, " +0x"); idx > 0 {
+		l = l[:idx]
+	}
+	if idx := strings.LastIndex(l, ":"); idx > 0 {
+		if n, err := strconv.Atoi(l[idx+1:]); err == nil {
+			f.file, f.line = l[:idx], n
+			return
+		}
+	}
+	f.file = l
+}
+
+// IsStdPackage tells if pkg is in the standard library (including runtime),
+// whose first path element has no dot.
+func IsStdPackage(pkg string) bool {
+	if pkg == "" || pkg == "main" {
+		return false
+	}
+	first := pkg
+	if idx := strings.Index(pkg, "/"); idx >= 0 {
+		first = pkg[:idx]
+	}
+	return !strings.Contains(first, ".")
+}
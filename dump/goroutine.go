@@ -0,0 +1,366 @@
+package dump
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/linuxerwang/goroutine-inspect/internal/text"
+)
+
+var (
+	durationPattern = regexp.MustCompile(`^\d+ minutes?$`)
+
+	// DedupModes are the ways to compare stack traces when deduplicating:
+	// "lines" compares the file lines of all frames, while "funcs" compares
+	// only the function names, so that goroutines waiting at different
+	// lines of the same functions are considered duplicated.
+	DedupModes = map[string]func(*Goroutine) string{
+		"lines": func(g *Goroutine) string {
+			return g.fullMd5
+		},
+		"funcs": func(g *Goroutine) string {
+			fns := make([]string, 0, len(g.frames))
+			for _, f := range g.frames {
+				fns = append(fns, f.fn)
+			}
+			return StackSignature(fns, g.CreatedByFunc())
+		},
+	}
+
+	// Flags appended to the state, in the reverse order of the Go runtime
+	// printing them: "scan" while being scanned by the GC, "leaked" for
+	// goroutines detected as leaked, "durable" for idle synctest goroutines.
+	stateFlags = []string{"durable", "scan", "leaked"}
+)
+
+// StackSignature returns the signature of a call stack by the function names,
+// the innermost first, as the "funcs" mode of DedupModes. Baseline files list
+// stacks by these names.
+func StackSignature(fns []string, createdBy string) string {
+	return strings.Join(append(fns, createdBy), "\n")
+}
+
+// Goroutine contains a goroutine info.
+type Goroutine struct {
+	id       int
+	header   string
+	trace    string
+	lines    int
+	duration int // In minutes.
+
+	// Metadata in the header, e.g. [syscall (scan), 5 minutes, locked to thread].
+	state  string   // The wait reason, e.g. "select (no cases)".
+	locked bool     // Locked to an OS thread.
+	flags  []string // Other flags, e.g. "scan".
+
+	labels map[string]string // The pprof labels.
+
+	frames    []*Frame // The call stack, the innermost frame first.
+	createdBy *Frame   // The go statement which created the goroutine.
+
+	lineMd5    []string
+	fullMd5    string
+	fullHasher hash.Hash
+	duplicates []int
+
+	// The delay measured by a profile for the stack, see Annotate().
+	delay       time.Duration
+	contentions int64
+
+	// The path prefixes of file lines to rewrite, and the lines before
+	// rewriting if any was rewritten.
+	rewrites  map[string]string
+	origTrace string
+	origBuf   *bytes.Buffer
+
+	frozen bool
+	buf    *bytes.Buffer
+}
+
+// ID returns the goroutine id.
+func (g *Goroutine) ID() int { return g.id }
+
+// Header returns the header line, e.g. "goroutine 7 [select, 5 minutes]:".
+func (g *Goroutine) Header() string { return g.header }
+
+// Trace returns the lines of the stack trace after the header.
+func (g *Goroutine) Trace() string { return g.trace }
+
+// Lines returns the number of lines, including the header.
+func (g *Goroutine) Lines() int { return g.lines }
+
+// Duration returns how long the goroutine has been blocked, in minutes.
+func (g *Goroutine) Duration() int { return g.duration }
+
+// State returns the wait reason, e.g. "select (no cases)".
+func (g *Goroutine) State() string { return g.state }
+
+// Locked tells if the goroutine is locked to an OS thread.
+func (g *Goroutine) Locked() bool { return g.locked }
+
+// Flags returns the other flags in the header, e.g. "scan".
+func (g *Goroutine) Flags() []string { return append([]string{}, g.flags...) }
+
+// Labels returns the pprof labels, if any.
+func (g *Goroutine) Labels() map[string]string {
+	labels := make(map[string]string, len(g.labels))
+	for k, v := range g.labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// Frames returns the call stack, the innermost frame first.
+func (g *Goroutine) Frames() []*Frame { return append([]*Frame{}, g.frames...) }
+
+// CreatedBy returns the go statement which created the goroutine, if known.
+func (g *Goroutine) CreatedBy() *Frame { return g.createdBy }
+
+// Duplicates returns the ids of the goroutines deduplicated into this one by
+// Dedup(), including its own.
+func (g *Goroutine) Duplicates() []int { return append([]int{}, g.duplicates...) }
+
+// Count returns the number of goroutines this one stands for, i.e. the number
+// of its duplicates after Dedup(), or 1.
+func (g *Goroutine) Count() int {
+	if len(g.duplicates) > 0 {
+		return len(g.duplicates)
+	}
+	return 1
+}
+
+// Delay returns the delay measured by a profile for the stack, and the number
+// of contentions or samples, see Annotate().
+func (g *Goroutine) Delay() (time.Duration, int64) { return g.delay, g.contentions }
+
+// addLine appends a line to the goroutine info.
+func (g *Goroutine) addLine(l string) {
+	if !g.frozen {
+		raw := l
+		if strings.HasPrefix(l, "\t") {
+			if path, ok := text.RewritePrefix(l[1:], g.rewrites); ok {
+				l = "\t" + path
+				if g.origBuf == nil {
+					g.origBuf = bytes.NewBufferString(g.buf.String())
+				}
+			}
+		}
+		if g.origBuf != nil {
+			g.origBuf.WriteString(raw)
+			g.origBuf.WriteString("\n")
+		}
+
+		g.lines++
+		g.buf.WriteString(l)
+		g.buf.WriteString("\n")
+
+		if !strings.HasPrefix(l, "\t") {
+			if strings.HasPrefix(l, "created by ") {
+				// Since Go 1.21: "created by main.main in goroutine 1".
+				fn := strings.TrimPrefix(l, "created by ")
+				if idx := strings.Index(fn, " in goroutine "); idx > 0 {
+					fn = fn[:idx]
+				}
+				g.createdBy = &Frame{fn: fn}
+			} else if !strings.HasPrefix(l, "...") {
+				g.frames = append(g.frames, parseFuncLine(l))
+			}
+		} else {
+			if g.createdBy != nil {
+				g.createdBy.parseFileLine(l)
+			} else if len(g.frames) > 0 {
+				g.frames[len(g.frames)-1].parseFileLine(l)
+			}
+
+			parts := strings.Split(l, " ")
+			fl := strings.TrimSpace(parts[0])
+
+			h := md5.New()
+			io.WriteString(h, fl)
+			g.lineMd5 = append(g.lineMd5, string(h.Sum(nil)))
+
+			io.WriteString(g.fullHasher, fl)
+		}
+	}
+}
+
+// IsProperty tells if name is a property of goroutines in conditions and
+// Sort(), e.g. "state" or "dups".
+func IsProperty(name string) bool {
+	_, ok := new(Goroutine).params()[name]
+	return ok
+}
+
+// params returns the properties of the goroutine used in conditions.
+func (g *Goroutine) params() map[string]interface{} {
+	return map[string]interface{}{
+		"id":       g.id,
+		"dups":     len(g.duplicates),
+		"duration": g.duration,
+		"lines":    g.lines,
+		"state":    g.state,
+		"locked":   g.locked,
+		"flags":    strings.Join(g.flags, ", "),
+		"trace":    g.trace,
+
+		"top":        g.TopFunc(),
+		"depth":      len(g.frames),
+		"created_by": g.CreatedByFunc(),
+		"packages":   strings.Join(g.Packages(), ", "),
+		"delay":      int(g.delay / time.Millisecond),
+	}
+}
+
+// TopFunc returns the function name of the innermost frame.
+func (g *Goroutine) TopFunc() string {
+	if len(g.frames) == 0 {
+		return ""
+	}
+	return g.frames[0].fn
+}
+
+// Packages returns the distinct package paths in the call stack, the
+// innermost first.
+func (g *Goroutine) Packages() []string {
+	seen := map[string]bool{}
+	pkgs := []string{}
+	for _, f := range g.frames {
+		if f.pkg != "" && !seen[f.pkg] {
+			seen[f.pkg] = true
+			pkgs = append(pkgs, f.pkg)
+		}
+	}
+	return pkgs
+}
+
+// OwnTopFunc returns the function name of the innermost frame outside the
+// runtime.
+func (g *Goroutine) OwnTopFunc() string {
+	for _, f := range g.frames {
+		if f.pkg != "runtime" {
+			return f.fn
+		}
+	}
+	return ""
+}
+
+// CreatedByFunc returns the function name of the goroutine's creator.
+func (g *Goroutine) CreatedByFunc() string {
+	if g.createdBy == nil {
+		return ""
+	}
+	return g.createdBy.fn
+}
+
+// freeze freezes the goroutine info.
+func (g *Goroutine) freeze() {
+	if !g.frozen {
+		g.frozen = true
+		g.trace = g.buf.String()
+		g.buf = nil
+		if g.origBuf != nil {
+			g.origTrace = g.origBuf.String()
+			g.origBuf = nil
+		}
+
+		g.fullMd5 = string(g.fullHasher.Sum(nil))
+	}
+}
+
+// Write writes the goroutine to w, with the ids of the duplicates after the
+// header if any.
+func (g *Goroutine) Write(w io.Writer) error {
+	if _, err := fmt.Fprint(w, g.header); err != nil {
+		return err
+	}
+	if len(g.duplicates) > 0 {
+		if _, err := fmt.Fprintf(w, " %d times: [[", len(g.duplicates)); err != nil {
+			return err
+		}
+		for i, id := range g.duplicates {
+			if i > 0 {
+				if _, err := fmt.Fprint(w, ", "); err != nil {
+					return err
+				}
+			}
+			if _, err := fmt.Fprint(w, id); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprint(w, "]"); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintln(w); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(w, g.trace); err != nil {
+		return err
+	}
+	return nil
+}
+
+// newGoroutine creates a goroutine by the header line. The lines of the stack
+// trace are added by addLine(), followed by freeze().
+func newGoroutine(metaline string) (*Goroutine, error) {
+	m := startLinePattern.FindStringSubmatch(metaline)
+	if m == nil {
+		return nil, fmt.Errorf("malformed goroutine header %q", metaline)
+	}
+	parts := strings.Split(m[2], ",")
+	state := strings.TrimSpace(parts[0])
+	flags := []string{}
+	for _, f := range stateFlags {
+		if strings.HasSuffix(state, " ("+f+")") {
+			state = strings.TrimSuffix(state, " ("+f+")")
+			flags = append(flags, f)
+		}
+	}
+
+	duration := 0
+	locked := false
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		switch {
+		case durationPattern.MatchString(p):
+			duration, _ = strconv.Atoi(p[:strings.Index(p, " ")])
+		case p == "locked to thread":
+			locked = true
+		case p != "":
+			flags = append(flags, p)
+		}
+	}
+
+	id, err := strconv.Atoi(m[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid goroutine id %s", m[1])
+	}
+
+	var labels map[string]string
+	if m[3] != "" {
+		if labels, err = parseHeaderLabels(m[3]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Goroutine{
+		id:         id,
+		lines:      1,
+		header:     metaline,
+		buf:        &bytes.Buffer{},
+		duration:   duration,
+		state:      state,
+		locked:     locked,
+		flags:      flags,
+		labels:     labels,
+		fullHasher: md5.New(),
+		duplicates: []int{},
+	}, nil
+}
@@ -0,0 +1,104 @@
+package dump
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewGoroutineHeader(t *testing.T) {
+	for _, tc := range []struct {
+		header   string
+		id       int
+		state    string
+		duration int
+		locked   bool
+		flags    []string
+		labels   map[string]string
+	}{
+		{
+			header: "goroutine 1 [running]:",
+			id:     1, state: "running", flags: []string{},
+		},
+		{
+			header: "goroutine 7 [syscall, 5 minutes, locked to thread]:",
+			id:     7, state: "syscall", duration: 5, locked: true, flags: []string{},
+		},
+		{
+			header: "goroutine 8 [select (scan), 1 minute]:",
+			id:     8, state: "select", duration: 1, flags: []string{"scan"},
+		},
+		{
+			header: "goroutine 9 [chan receive (leaked)]:",
+			id:     9, state: "chan receive", flags: []string{"leaked"},
+		},
+		{
+			header: "goroutine 10 [sleep, synctest bubble 3]:",
+			id:     10, state: "sleep", flags: []string{"synctest bubble 3"},
+		},
+		{
+			header: "goroutine 11 gp=0xc000002380 m=0 mp=0x5d8ae0 [running]:",
+			id:     11, state: "running", flags: []string{},
+		},
+		{
+			header: `goroutine 12 [select] {handler: api, "user agent": "curl/8.0"}:`,
+			id:     12, state: "select", flags: []string{},
+			labels: map[string]string{"handler": "api", "user agent": "curl/8.0"},
+		},
+	} {
+		g, err := newGoroutine(tc.header)
+		if err != nil {
+			t.Errorf("newGoroutine(%q) error: %s", tc.header, err)
+			continue
+		}
+		if g.ID() != tc.id || g.State() != tc.state || g.Duration() != tc.duration || g.Locked() != tc.locked {
+			t.Errorf("newGoroutine(%q) = id %d, state %q, duration %d, locked %t", tc.header, g.ID(), g.State(), g.Duration(), g.Locked())
+		}
+		if !reflect.DeepEqual(g.Flags(), tc.flags) {
+			t.Errorf("newGoroutine(%q) flags = %q, want %q", tc.header, g.Flags(), tc.flags)
+		}
+		if len(g.Labels()) > 0 || len(tc.labels) > 0 {
+			if !reflect.DeepEqual(g.Labels(), tc.labels) {
+				t.Errorf("newGoroutine(%q) labels = %q, want %q", tc.header, g.Labels(), tc.labels)
+			}
+		}
+	}
+
+	for _, header := range []string{
+		"goroutine 1 [running",
+		`goroutine 1 [select] {handler api}:`,
+	} {
+		if _, err := newGoroutine(header); err == nil {
+			t.Errorf("newGoroutine(%q) succeeded, want an error", header)
+		}
+	}
+}
+
+func TestFormatHeaderLabels(t *testing.T) {
+	labels := map[string]string{"handler": "api", "user agent": "curl/8.0"}
+	s := formatHeaderLabels(labels)
+	if want := `{handler: api, "user agent": curl/8.0}`; s != want {
+		t.Fatalf("formatHeaderLabels() = %s, want %s", s, want)
+	}
+	parsed, err := parseHeaderLabels(strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}"))
+	if err != nil || !reflect.DeepEqual(parsed, labels) {
+		t.Errorf("parseHeaderLabels(%s) = %v, %v, want %v", s, parsed, err, labels)
+	}
+}
+
+func TestAccessorsReturnCopies(t *testing.T) {
+	gd := mustParse(t, "goroutine 8 [select (scan)] {handler: api}:\nmain.worker()\n\t/src/main.go:20 +0x30\n")
+	if err := gd.Dedup("lines"); err != nil {
+		t.Fatal(err)
+	}
+	g := gd.Goroutines()[0]
+
+	g.Flags()[0] = "changed"
+	g.Labels()["handler"] = "changed"
+	g.Frames()[0] = nil
+	g.Duplicates()[0] = 0
+
+	if g.Flags()[0] != "scan" || g.Labels()["handler"] != "api" || g.Frames()[0] == nil || g.Duplicates()[0] != 8 {
+		t.Errorf("goroutine changed through its accessors: flags %q, labels %v, frames %v, duplicates %v", g.Flags(), g.Labels(), g.Frames(), g.Duplicates())
+	}
+}
@@ -0,0 +1,263 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/linuxerwang/goroutine-inspect/internal/text"
+)
+
+var (
+	// Since Go 1.21, the header may also carry fields like "gp=0x... m=0"
+	// before the bracket when traced with GOTRACEBACK=crash, and recent Go
+	// versions append the pprof labels after the bracket.
+	startLinePattern  = regexp.MustCompile(`^goroutine\s+(\d+)(?:\s+[^\[\]]*?)?\s+\[([^\]]*)\](?:\s+\{(.*)\})?:$`)
+	headerLikePattern = regexp.MustCompile(`^goroutine\s+\d`)
+
+	// Known per-line prefixes added by log collectors.
+	criPattern       = regexp.MustCompile(`^\S+ (stdout|stderr) ([FP]) ?`)
+	journaldPattern  = regexp.MustCompile(`^[A-Z][a-z]{2} [ 0-9]\d \d{2}:\d{2}:\d{2} \S+ [^\s:\[]+(\[\d+\])?: ?`)
+	timestampPattern = regexp.MustCompile(`^\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})? ?`)
+)
+
+// LineDecoder recovers the goroutine dump line carried by a raw log line. It
+// returns false if the log line doesn't carry (a complete) dump line.
+type LineDecoder func(string) (string, bool)
+
+// prefixDecoders lists the log formats DetectDecoder() tries.
+var prefixDecoders = []struct {
+	name string
+	new  func() LineDecoder
+}{
+	{"kubelet cri", newCRIDecoder},
+	{"docker json-file", newDockerJSONDecoder},
+	{"journald", func() LineDecoder { return NewRegexpDecoder(journaldPattern) }},
+	{"timestamp", func() LineDecoder { return NewRegexpDecoder(timestampPattern) }},
+}
+
+// Parser reads goroutine dumps.
+type Parser struct {
+	// Decoder, if not nil, is applied to every line before parsing, to
+	// extract a dump embedded in a log file.
+	Decoder LineDecoder
+
+	// Rewrites maps the path prefixes of file lines to rewrite, e.g. the
+	// build directory to the local checkout.
+	Rewrites map[string]string
+
+	// Warn, if not nil, is called with the line number and the error of
+	// each malformed goroutine section, which is skipped.
+	Warn func(line int, err error)
+}
+
+// Parse reads a goroutine dump, or a goroutine profile written with debug=1
+// or in the protobuf format.
+func Parse(r io.Reader) (*GoroutineDump, error) {
+	return (&Parser{}).Parse(r)
+}
+
+// Parse reads a goroutine dump, or a goroutine profile written with debug=1
+// or in the protobuf format if there is no decoder.
+func (p *Parser) Parse(r io.Reader) (*GoroutineDump, error) {
+	if p.Decoder == nil {
+		br := bufio.NewReader(r)
+		if isGzipped(br) {
+			data, err := io.ReadAll(br)
+			if err != nil {
+				return nil, err
+			}
+			return p.parseProfile(data)
+		}
+		if isDebug1(br) {
+			return p.parseDebug1(br)
+		}
+		r = br
+	}
+	dumps, err := p.parseDumps(r, false)
+	if err != nil {
+		return nil, err
+	}
+	return dumps[0], nil
+}
+
+// ParseAll reads several goroutine dumps, e.g. produced by sending SIGQUIT
+// repeatedly or by concatenating periodic dumps, and returns them as separate
+// snapshots.
+func (p *Parser) ParseAll(r io.Reader) ([]*GoroutineDump, error) {
+	return p.parseDumps(r, true)
+}
+
+// newGoroutine creates a goroutine by the header line, rewriting the paths
+// by the parser.
+func (p *Parser) newGoroutine(metaline string) (*Goroutine, error) {
+	g, err := newGoroutine(metaline)
+	if err != nil {
+		return nil, err
+	}
+	g.rewrites = p.Rewrites
+	return g, nil
+}
+
+// parseDumps reads goroutine dumps from r. If split is true, a new dump is
+// started whenever a goroutine header follows a line outside of any goroutine
+// section (panic headers, timestamps, separators etc.), or when a goroutine
+// id repeats within the current dump. Otherwise everything is returned as a
+// single dump.
+func (p *Parser) parseDumps(r io.Reader, split bool) ([]*GoroutineDump, error) {
+	dump := New()
+	dumps := []*GoroutineDump{dump}
+	ids := map[int]bool{}
+	boundary := false
+	skipping := false
+	var goroutine *Goroutine
+
+	err := text.ReadLines(r, func(n int, line string) {
+		if p.Decoder != nil {
+			var ok bool
+			if line, ok = p.Decoder(line); !ok {
+				return
+			}
+		}
+		if headerLikePattern.MatchString(line) {
+			if goroutine != nil {
+				goroutine.freeze()
+			}
+			g, err := p.newGoroutine(line)
+			if err != nil {
+				// Skip the malformed section but keep loading the rest.
+				if p.Warn != nil {
+					p.Warn(n, err)
+				}
+				goroutine = nil
+				skipping = true
+				return
+			}
+			goroutine = g
+			skipping = false
+			if split && len(dump.goroutines) > 0 && (boundary || ids[goroutine.id]) {
+				dump = New()
+				dumps = append(dumps, dump)
+				ids = map[int]bool{}
+			}
+			boundary = false
+			ids[goroutine.id] = true
+			dump.Add(goroutine)
+		} else if line == "" {
+			// End of a goroutine section.
+			if goroutine != nil {
+				goroutine.freeze()
+			}
+			goroutine = nil
+			skipping = false
+		} else if goroutine != nil {
+			goroutine.addLine(line)
+		} else if !skipping {
+			boundary = true
+		}
+	})
+
+	if goroutine != nil {
+		goroutine.freeze()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return dumps, nil
+}
+
+// DetectDecoder returns the log format whose decoder recovers the most
+// goroutine headers from data. A nil decoder is returned for a plain dump,
+// and an empty name if no goroutine header is found.
+func DetectDecoder(data []byte) (string, LineDecoder) {
+	best := countHeaders(data, nil)
+	name, dec := "", LineDecoder(nil)
+	if best > 0 {
+		name = "plain"
+	}
+	for _, pd := range prefixDecoders {
+		if n := countHeaders(data, pd.new()); n > best {
+			best = n
+			name, dec = pd.name, pd.new()
+		}
+	}
+	return name, dec
+}
+
+func countHeaders(data []byte, dec LineDecoder) int {
+	count := 0
+	text.ReadLines(bytes.NewReader(data), func(n int, line string) {
+		if dec != nil {
+			var ok bool
+			if line, ok = dec(line); !ok {
+				return
+			}
+		}
+		if startLinePattern.MatchString(line) {
+			count++
+		}
+	})
+	return count
+}
+
+// NewRegexpDecoder strips the prefix matched by re. Lines not starting with
+// the prefix are skipped.
+func NewRegexpDecoder(re *regexp.Regexp) LineDecoder {
+	return func(l string) (string, bool) {
+		loc := re.FindStringIndex(l)
+		if loc == nil || loc[0] != 0 {
+			return "", false
+		}
+		return l[loc[1]:], true
+	}
+}
+
+// newCRIDecoder decodes the kubelet CRI log format:
+//
+//	2017-05-10T17:02:45.123456789Z stderr F goroutine 5 [select]:
+//
+// Partial lines (tagged "P") are joined with the following lines.
+func newCRIDecoder() LineDecoder {
+	var partial bytes.Buffer
+	return func(l string) (string, bool) {
+		m := criPattern.FindStringSubmatch(l)
+		if m == nil {
+			return "", false
+		}
+		partial.WriteString(l[len(m[0]):])
+		if m[2] == "P" {
+			return "", false
+		}
+		l = partial.String()
+		partial.Reset()
+		return l, true
+	}
+}
+
+// newDockerJSONDecoder decodes the docker json-file log format:
+//
+//	{"log":"goroutine 5 [select]:\n","stream":"stderr","time":"..."}
+//
+// Log entries without a trailing newline are joined with the following ones.
+func newDockerJSONDecoder() LineDecoder {
+	var partial bytes.Buffer
+	return func(l string) (string, bool) {
+		var entry struct {
+			Log string `json:"log"`
+		}
+		if !strings.HasPrefix(l, "{") || json.Unmarshal([]byte(l), &entry) != nil {
+			return "", false
+		}
+		partial.WriteString(entry.Log)
+		if !strings.HasSuffix(entry.Log, "\n") {
+			return "", false
+		}
+		l = strings.TrimRight(partial.String(), "\r\n")
+		partial.Reset()
+		return l, true
+	}
+}
@@ -0,0 +1,246 @@
+package dump
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sampleDump has goroutines 5 and 6 at the same lines, and goroutine 7 in the
+// same functions at another line.
+const sampleDump = `goroutine 1 [running]:
+main.main()
+	/src/main.go:10 +0x20
+
+goroutine 5 [chan receive, 12 minutes]:
+main.worker(0xc000010000)
+	/src/main.go:20 +0x30
+created by main.main in goroutine 1
+	/src/main.go:12 +0x40
+
+goroutine 6 [chan receive, 12 minutes]:
+main.worker(0xc000010000)
+	/src/main.go:20 +0x30
+created by main.main in goroutine 1
+	/src/main.go:12 +0x40
+
+goroutine 7 [chan receive, 3 minutes]:
+main.worker(0xc000010000)
+	/src/main.go:21 +0x38
+created by main.main in goroutine 1
+	/src/main.go:12 +0x40
+`
+
+func mustParse(t *testing.T, s string) *GoroutineDump {
+	t.Helper()
+	gd, err := Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("Parse() error: %s", err)
+	}
+	return gd
+}
+
+func ids(gd *GoroutineDump) []int {
+	ids := []int{}
+	for _, g := range gd.Goroutines() {
+		ids = append(ids, g.ID())
+	}
+	return ids
+}
+
+func TestParse(t *testing.T) {
+	gd := mustParse(t, sampleDump)
+	if got, want := ids(gd), []int{1, 5, 6, 7}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ids = %v, want %v", got, want)
+	}
+
+	g := gd.Goroutines()[1]
+	if g.State() != "chan receive" || g.Duration() != 12 || g.Lines() != 5 {
+		t.Errorf("goroutine 5: state %q, duration %d, lines %d", g.State(), g.Duration(), g.Lines())
+	}
+	frames := g.Frames()
+	if len(frames) != 1 {
+		t.Fatalf("goroutine 5: %d frames, want 1", len(frames))
+	}
+	f := frames[0]
+	if f.Func() != "main.worker" || f.Package() != "main" || f.Args() != "0xc000010000" || f.File() != "/src/main.go" || f.Line() != 20 {
+		t.Errorf("goroutine 5 frame: %s %s (%s) %s:%d", f.Func(), f.Package(), f.Args(), f.File(), f.Line())
+	}
+	if c := g.CreatedBy(); c == nil || c.Func() != "main.main" || c.Line() != 12 {
+		t.Errorf("goroutine 5 created by %+v, want main.main at line 12", c)
+	}
+	if want := "main.worker(0xc000010000)\n\t/src/main.go:20 +0x30\ncreated by main.main in goroutine 1\n\t/src/main.go:12 +0x40\n"; g.Trace() != want {
+		t.Errorf("goroutine 5 trace = %q, want %q", g.Trace(), want)
+	}
+}
+
+func TestParseWarnsMalformed(t *testing.T) {
+	var warned []int
+	p := &Parser{Warn: func(n int, err error) { warned = append(warned, n) }}
+	gd, err := p.Parse(strings.NewReader("goroutine 3 [running\nmain.main()\n\n" + sampleDump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(warned, []int{1}) {
+		t.Errorf("warned at lines %v, want [1]", warned)
+	}
+	if got, want := ids(gd), []int{1, 5, 6, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ids = %v, want %v", got, want)
+	}
+}
+
+func TestParseRewrites(t *testing.T) {
+	p := &Parser{Rewrites: map[string]string{"/src/": "/home/me/src/"}}
+	gd, err := p.Parse(strings.NewReader(sampleDump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file := gd.Goroutines()[0].Frames()[0].File(); file != "/home/me/src/main.go" {
+		t.Errorf("file = %s, want /home/me/src/main.go", file)
+	}
+}
+
+func TestParseDebug1(t *testing.T) {
+	const profile = `goroutine profile: total 3
+2 @ 0x43b0c5 0x46f7a4
+# labels: {"handler":"api"}
+#	0x46f7a3	main.worker+0x23	/src/main.go:20
+
+1 @ 0x4a1b2c
+`
+	gd := mustParse(t, profile)
+	if got, want := ids(gd), []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ids = %v, want %v", got, want)
+	}
+	g := gd.Goroutines()[0]
+	if g.State() != "unknown" || g.TopFunc() != "main.worker" || g.Labels()["handler"] != "api" {
+		t.Errorf("goroutine 1: state %q, top %q, labels %v", g.State(), g.TopFunc(), g.Labels())
+	}
+	// Not symbolized, so the PCs are kept.
+	if trace := gd.Goroutines()[2].Trace(); trace != "0x4a1b2c\n" {
+		t.Errorf("goroutine 3 trace = %q, want the PC", trace)
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want [][]int
+	}{
+		{
+			name: "separated",
+			in:   sampleDump + "\n2024-01-02 03:04:05 SIGQUIT: quit\n\n" + sampleDump,
+			want: [][]int{{1, 5, 6, 7}, {1, 5, 6, 7}},
+		},
+		{
+			name: "repeated id",
+			in:   sampleDump + "\n" + sampleDump,
+			want: [][]int{{1, 5, 6, 7}, {1, 5, 6, 7}},
+		},
+		{
+			name: "single",
+			in:   sampleDump,
+			want: [][]int{{1, 5, 6, 7}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dumps, err := (&Parser{}).ParseAll(strings.NewReader(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got [][]int
+			for _, d := range dumps {
+				got = append(got, ids(d))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ids = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDetectDecoder(t *testing.T) {
+	lines := strings.Split(strings.TrimSuffix(sampleDump, "\n"), "\n")
+	prefixed := func(prefix func(i int, l string) string) string {
+		var sb strings.Builder
+		sb.WriteString(prefix(-1, "starting server"))
+		sb.WriteString("\n")
+		for i, l := range lines {
+			sb.WriteString(prefix(i, l))
+			sb.WriteString("\n")
+		}
+		return sb.String()
+	}
+
+	for _, tc := range []struct {
+		name string
+		log  string
+	}{
+		{"plain", sampleDump},
+		{"kubelet cri", prefixed(func(i int, l string) string {
+			// The first goroutine header is split into a partial line.
+			if i == 0 {
+				return "2024-01-02T03:04:05.123456789Z stderr P goroutine 1 \n2024-01-02T03:04:05.123456789Z stderr F [running]:"
+			}
+			return "2024-01-02T03:04:05.123456789Z stderr F " + l
+		})},
+		{"docker json-file", prefixed(func(i int, l string) string {
+			b, _ := json.Marshal(map[string]string{"log": l + "\n", "stream": "stderr"})
+			return string(b)
+		})},
+		{"journald", prefixed(func(i int, l string) string {
+			return "Jan 02 03:04:05 host server[1234]: " + l
+		})},
+		{"timestamp", prefixed(func(i int, l string) string {
+			return "2024-01-02T03:04:05.123Z " + l
+		})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			name, dec := DetectDecoder([]byte(tc.log))
+			if name != tc.name {
+				t.Fatalf("DetectDecoder() = %q, want %q", name, tc.name)
+			}
+			gd, err := (&Parser{Decoder: dec}).Parse(strings.NewReader(tc.log))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := mustParse(t, sampleDump)
+			if got := ids(gd); !reflect.DeepEqual(got, ids(want)) {
+				t.Fatalf("ids = %v, want %v", got, ids(want))
+			}
+			for i, g := range gd.Goroutines() {
+				if w := want.Goroutines()[i]; g.Header() != w.Header() || g.Trace() != w.Trace() {
+					t.Errorf("goroutine %d = %q %q, want %q %q", g.ID(), g.Header(), g.Trace(), w.Header(), w.Trace())
+				}
+			}
+		})
+	}
+
+	if name, _ := DetectDecoder([]byte("no dump here\n")); name != "" {
+		t.Errorf("DetectDecoder() = %q for a log without dumps", name)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	wantErr := errors.New("read failed")
+	if _, err := Parse(&failingReader{err: wantErr}); err != wantErr {
+		t.Errorf("Parse() error = %v, want %v", err, wantErr)
+	}
+}
+
+// failingReader returns a line and then an error.
+type failingReader struct {
+	err  error
+	done bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, r.err
+	}
+	r.done = true
+	return copy(p, "goroutine 1 [running]:\n"), nil
+}
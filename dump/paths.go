@@ -0,0 +1,44 @@
+package dump
+
+import (
+	"strings"
+)
+
+// reparse parses the goroutine again from the lines in the dump, rewriting
+// the path prefixes of file lines by rewrites.
+func (g *Goroutine) reparse(rewrites map[string]string) *Goroutine {
+	trace := g.origTrace
+	if trace == "" {
+		trace = g.trace
+	}
+	ng, err := newGoroutine(g.header)
+	if err != nil {
+		// Not possible as the header is parsed before.
+		return g
+	}
+	ng.rewrites = rewrites
+	for _, l := range strings.Split(strings.TrimSuffix(trace, "\n"), "\n") {
+		ng.addLine(l)
+	}
+	ng.freeze()
+	ng.duplicates = g.duplicates
+	ng.delay, ng.contentions = g.delay, g.contentions
+	return ng
+}
+
+// RewritePaths rewrites the path prefixes of file lines by rewrites, from the
+// paths as they are in the dump, and returns the number of goroutines
+// changed. Nil rewrites restore the paths.
+func (gd *GoroutineDump) RewritePaths(rewrites map[string]string) int {
+	changed := 0
+	for i, g := range gd.goroutines {
+		ng := g.reparse(rewrites)
+		if ng.trace != g.trace {
+			changed++
+		}
+		// Replaced rather than modified, as goroutines are shared by copies
+		// of the dump.
+		gd.goroutines[i] = ng
+	}
+	return changed
+}
@@ -0,0 +1,252 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+	"github.com/linuxerwang/goroutine-inspect/internal/text"
+)
+
+var (
+	debug1RecordPattern = regexp.MustCompile(`^(\d+) @(?: 0x[0-9a-f]+)*$`)
+	debug1FramePattern  = regexp.MustCompile(`^#\t0x[0-9a-f]+\t(\S+?)(?:\+(0x[0-9a-f]+))?\t+(.*)$`)
+
+	labelsPattern = regexp.MustCompile(`^# labels: \{(.*)\}$`)
+	labelPattern  = regexp.MustCompile(`"((?:[^"\\]|\\.)*)":"((?:[^"\\]|\\.)*)"`)
+
+	// A key or value in the labels of a goroutine header, quoted only if it
+	// has characters other than [a-zA-Z0-9./_].
+	unquotedLabelPattern = regexp.MustCompile(`^[a-zA-Z0-9./_]*$`)
+	headerLabelPattern   = regexp.MustCompile(`^("(?:[^"\\]|\\.)*"|[a-zA-Z0-9./_]*): ("(?:[^"\\]|\\.)*"|[a-zA-Z0-9./_]*)(?:, |$)`)
+)
+
+// parseLabels parses the pprof labels in the form of:
+//
+//	# labels: {"handler":"api", "tenant":"acme"}
+func parseLabels(l string) (map[string]string, bool) {
+	m := labelsPattern.FindStringSubmatch(l)
+	if m == nil {
+		return nil, false
+	}
+	labels := map[string]string{}
+	for _, kv := range labelPattern.FindAllStringSubmatch(m[1], -1) {
+		k, err := strconv.Unquote(`"` + kv[1] + `"`)
+		if err != nil {
+			continue
+		}
+		v, err := strconv.Unquote(`"` + kv[2] + `"`)
+		if err != nil {
+			continue
+		}
+		labels[k] = v
+	}
+	return labels, true
+}
+
+// parseHeaderLabels parses the pprof labels in a goroutine header, e.g. the
+// part in braces of:
+//
+//	goroutine 7 [select] {handler: api, "user agent": "curl/8.0"}:
+func parseHeaderLabels(l string) (map[string]string, error) {
+	labels := map[string]string{}
+	for l != "" {
+		m := headerLabelPattern.FindStringSubmatch(l)
+		if m == nil {
+			return nil, fmt.Errorf("malformed goroutine labels {%s}", l)
+		}
+		l = l[len(m[0]):]
+		kv := m[1:]
+		for i, s := range kv {
+			if strings.HasPrefix(s, "\"") {
+				var err error
+				if kv[i], err = strconv.Unquote(s); err != nil {
+					return nil, fmt.Errorf("malformed goroutine label %s", s)
+				}
+			}
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels, nil
+}
+
+// formatHeaderLabels formats labels for a goroutine header the same way as
+// the Go runtime does.
+func formatHeaderLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	quote := func(s string) string {
+		if unquotedLabelPattern.MatchString(s) {
+			return s
+		}
+		return strconv.Quote(s)
+	}
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, quote(k)+": "+quote(labels[k]))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// profileRecord is a stack shared by a number of goroutines in a goroutine
+// profile.
+type profileRecord struct {
+	count  int
+	labels map[string]string
+	lines  []string
+	pcs    []string // The PCs, as the stack if it isn't symbolized.
+}
+
+// addTo adds the goroutines of the record to the dump. Goroutine profiles
+// don't carry goroutine ids and states, so ids are assigned sequentially and
+// states are unknown.
+func (pr *profileRecord) addTo(p *Parser, dump *GoroutineDump) error {
+	labels := ""
+	if len(pr.labels) > 0 {
+		labels = " " + formatHeaderLabels(pr.labels)
+	}
+	lines := pr.lines
+	if len(lines) == 0 {
+		// Left for Symbolize().
+		lines = pr.pcs
+	}
+	for i := 0; i < pr.count; i++ {
+		g, err := p.newGoroutine(fmt.Sprintf("goroutine %d [unknown]%s:", len(dump.goroutines)+1, labels))
+		if err != nil {
+			return err
+		}
+		for _, l := range lines {
+			g.addLine(l)
+		}
+		g.freeze()
+		dump.Add(g)
+	}
+	return nil
+}
+
+// parseDebug1 reads a goroutine profile written with debug=1:
+//
+//	goroutine profile: total 3
+//	2 @ 0x43b0c5 0x46f7a4
+//	# labels: {"handler":"api"}
+//	#	0x46f7a4	net/http.(*Server).Serve+0x1f4	/usr/local/go/src/net/http/server.go:2933
+//
+// Frames are converted into the same format as a goroutine dump. Without the
+// "#" lines, i.e. not symbolized, the PCs are kept for Symbolize().
+func (p *Parser) parseDebug1(r io.Reader) (*GoroutineDump, error) {
+	dump := New()
+	var record *profileRecord
+	var recErr error
+
+	err := text.ReadLines(r, func(n int, line string) {
+		if recErr != nil {
+			return
+		}
+		if m := debug1RecordPattern.FindStringSubmatch(line); m != nil {
+			if record != nil {
+				recErr = record.addTo(p, dump)
+			}
+			count, _ := strconv.Atoi(m[1])
+			record = &profileRecord{count: count, pcs: strings.Fields(line)[2:]}
+			return
+		}
+		if record == nil {
+			return
+		}
+		if labels, ok := parseLabels(line); ok {
+			record.labels = labels
+		} else if m := debug1FramePattern.FindStringSubmatch(line); m != nil {
+			record.lines = append(record.lines, m[1]+"(...)")
+			if m[2] != "" {
+				record.lines = append(record.lines, "\t"+m[3]+" +"+m[2])
+			} else {
+				record.lines = append(record.lines, "\t"+m[3])
+			}
+		} else if line == "" {
+			recErr = record.addTo(p, dump)
+			record = nil
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if recErr != nil {
+		return nil, recErr
+	}
+	if record != nil {
+		if err := record.addTo(p, dump); err != nil {
+			return nil, err
+		}
+	}
+	return dump, nil
+}
+
+// parseProfile reads a goroutine profile in the protobuf format (debug=0).
+func (p *Parser) parseProfile(data []byte) (*GoroutineDump, error) {
+	prof, err := profile.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	dump := New()
+	if len(prof.Mapping) > 0 {
+		// The first mapping is the main binary.
+		dump.buildID = prof.Mapping[0].BuildID
+	}
+	for _, s := range prof.Sample {
+		if len(s.Value) == 0 {
+			continue
+		}
+		record := &profileRecord{count: int(s.Value[0])}
+		if len(s.Label) > 0 {
+			record.labels = map[string]string{}
+			for k, vs := range s.Label {
+				record.labels[k] = strings.Join(vs, ",")
+			}
+		}
+		for _, loc := range s.Location {
+			if len(loc.Line) == 0 {
+				// Not symbolized, left for Symbolize().
+				record.lines = append(record.lines, fmt.Sprintf("0x%x", loc.Address))
+				continue
+			}
+			// The last line is the caller into which the preceding lines
+			// were inlined.
+			for _, l := range loc.Line {
+				if l.Function == nil {
+					continue
+				}
+				record.lines = append(record.lines,
+					l.Function.Name+"(...)",
+					fmt.Sprintf("\t%s:%d", l.Function.Filename, l.Line))
+			}
+		}
+		if err := record.addTo(p, dump); err != nil {
+			return nil, err
+		}
+	}
+	return dump, nil
+}
+
+// isDebug1 tells if the content read by br is a debug=1 goroutine profile.
+func isDebug1(br *bufio.Reader) bool {
+	l, _ := br.Peek(len("goroutine profile: total "))
+	return bytes.Equal(l, []byte("goroutine profile: total "))
+}
+
+// isGzipped tells if the content read by br is gzipped, which is the case for
+// goroutine profiles in the protobuf format.
+func isGzipped(br *bufio.Reader) bool {
+	magic, _ := br.Peek(2)
+	return len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+}
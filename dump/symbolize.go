@@ -0,0 +1,288 @@
+package dump
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"debug/gosym"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pcLinePattern matches a line of bare PCs in a stack trace, e.g. the "@"
+// line of an unsymbolized goroutine profile or runtime.Callers() printed by a
+// crash reporter:
+//
+//	0x43b0c5 0x46f7a4 0x46fa1c
+var pcLinePattern = regexp.MustCompile(`^\s*0x[0-9a-fA-F]+(?:\s+0x[0-9a-fA-F]+)*\s*$`)
+
+// Symbolizer resolves PCs into functions and file lines by the line table of
+// a Go binary.
+type Symbolizer struct {
+	table    *gosym.Table
+	inlined  map[uint64][]inlinedCall // By the entry of the function inlined into.
+	buildID  string                   // The GNU build ID of the binary, if any.
+	pcs      int                      // The number of PCs looked up.
+	notFound int                      // The number of PCs not found in the binary.
+}
+
+// inlinedCall is the code of a function inlined into another one, read from
+// the DWARF inlined subroutine entries.
+type inlinedCall struct {
+	fn     string
+	ranges [][2]uint64
+	depth  int // 1 if inlined into the function itself, 2 if into another inlined call, etc.
+}
+
+// NewSymbolizer reads the line table of a Go ELF binary, which must be the
+// one producing the stack traces. The inlined functions are read from the
+// DWARF data if any; for a binary built with -ldflags=-w, the frames of an
+// inlined function are attributed to the function it's inlined into.
+func NewSymbolizer(binary string) (*Symbolizer, error) {
+	f, err := elf.Open(binary)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	text, pclntab := f.Section(".text"), f.Section(".gopclntab")
+	if text == nil || pclntab == nil {
+		return nil, fmt.Errorf("%s has no Go line table", binary)
+	}
+	pcln, err := pclntab.Data()
+	if err != nil {
+		return nil, err
+	}
+	// Empty since Go 1.3, but still read if any.
+	var symtab []byte
+	if s := f.Section(".gosymtab"); s != nil {
+		if symtab, err = s.Data(); err != nil {
+			return nil, err
+		}
+	}
+	table, err := gosym.NewTable(symtab, gosym.NewLineTable(pcln, text.Addr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Go line table in %s: %s", binary, err)
+	}
+	s := &Symbolizer{table: table, buildID: gnuBuildID(f)}
+	if d, err := f.DWARF(); err == nil {
+		if s.inlined, err = readInlinedCalls(d); err != nil {
+			return nil, fmt.Errorf("invalid DWARF data in %s: %s", binary, err)
+		}
+	}
+	return s, nil
+}
+
+// gnuBuildID returns the GNU build ID of an ELF binary in hex, as recorded in
+// the mappings of a pprof profile, or "" if there is none.
+func gnuBuildID(f *elf.File) string {
+	sec := f.Section(".note.gnu.build-id")
+	if sec == nil {
+		return ""
+	}
+	data, err := sec.Data()
+	// The note header is the name size, the description size and the type,
+	// followed by the name "GNU\x00" and the build ID as the description.
+	if err != nil || len(data) < 16 {
+		return ""
+	}
+	nameSize, descSize := f.ByteOrder.Uint32(data), f.ByteOrder.Uint32(data[4:])
+	start := 12 + (nameSize+3)/4*4
+	if nameSize != 4 || string(data[12:16]) != "GNU\x00" || uint64(start)+uint64(descSize) > uint64(len(data)) {
+		return ""
+	}
+	return hex.EncodeToString(data[start : start+descSize])
+}
+
+// readInlinedCalls returns the inlined calls in the DWARF data, by the entry
+// of the function they're inlined into.
+func readInlinedCalls(d *dwarf.Data) (map[uint64][]inlinedCall, error) {
+	type call struct {
+		entry  uint64
+		origin dwarf.Offset
+		inlinedCall
+	}
+	// The open entries with children, the innermost last.
+	type open struct {
+		tag   dwarf.Tag
+		entry uint64
+		depth int
+	}
+	var (
+		stack []open
+		calls []call
+	)
+	names := map[dwarf.Offset]string{}
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag == 0 {
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+
+		var parent open
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+		cur := open{tag: e.Tag, entry: parent.entry, depth: parent.depth}
+		switch e.Tag {
+		case dwarf.TagSubprogram:
+			// Abstract functions only have names, and concrete ones of
+			// inlinable functions only have PCs.
+			if name, ok := e.Val(dwarf.AttrName).(string); ok {
+				names[e.Offset] = name
+			}
+			if entry, ok := e.Val(dwarf.AttrLowpc).(uint64); ok {
+				cur.entry, cur.depth = entry, 0
+			}
+		case dwarf.TagInlinedSubroutine:
+			ranges, err := d.Ranges(e)
+			if err != nil {
+				return nil, err
+			}
+			origin, _ := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+			cur.depth++
+			calls = append(calls, call{
+				entry:       cur.entry,
+				origin:      origin,
+				inlinedCall: inlinedCall{ranges: ranges, depth: cur.depth},
+			})
+		}
+		if e.Children {
+			stack = append(stack, cur)
+		}
+	}
+
+	inlined := map[uint64][]inlinedCall{}
+	for _, c := range calls {
+		if c.fn = names[c.origin]; c.fn != "" {
+			inlined[c.entry] = append(inlined[c.entry], c.inlinedCall)
+		}
+	}
+	return inlined, nil
+}
+
+// funcName returns the name of the function at the PC in fn, which is the
+// innermost function inlined there if any.
+func (s *Symbolizer) funcName(pc uint64, fn *gosym.Func) (name string, inlined bool) {
+	name, depth := fn.Name, 0
+	for _, c := range s.inlined[fn.Entry] {
+		if c.depth <= depth {
+			continue
+		}
+		for _, r := range c.ranges {
+			if pc >= r[0] && pc < r[1] {
+				name, depth = c.fn, c.depth
+				break
+			}
+		}
+	}
+	return name, depth > 0
+}
+
+// BuildID returns the GNU build ID of the binary in hex, or "" if it has none.
+// It's compared with GoroutineDump.BuildID() to tell if the binary is the one
+// producing the dump.
+func (s *Symbolizer) BuildID() string {
+	return s.buildID
+}
+
+// NotFound returns the number of PCs not found in the binary and the number
+// of PCs looked up so far. PCs not found suggest that it isn't the binary
+// producing the dump.
+func (s *Symbolizer) NotFound() (notFound, total int) {
+	return s.notFound, s.pcs
+}
+
+// lines returns the stack trace lines of the PCs in a line, in the same
+// format as a goroutine dump. PCs not found in the binary are kept as they
+// are. As with runtime.Callers(), which gives a PC for each inlined call,
+// each PC is a single frame.
+func (s *Symbolizer) lines(l string) []string {
+	var lines []string
+	for _, word := range strings.Fields(l) {
+		pc, err := strconv.ParseUint(word[2:], 16, 64)
+		if err != nil {
+			lines = append(lines, word)
+			continue
+		}
+		// The PCs are return addresses, so look up the call instruction
+		// before them.
+		s.pcs++
+		file, line, fn := s.table.PCToLine(pc - 1)
+		if fn == nil {
+			s.notFound++
+			lines = append(lines, word)
+			continue
+		}
+		// Like in goroutine dumps, inlined frames have no PC offset.
+		name, inlined := s.funcName(pc-1, fn)
+		if inlined {
+			lines = append(lines, name+"(...)", fmt.Sprintf("\t%s:%d", file, line))
+		} else {
+			lines = append(lines, name+"(...)", fmt.Sprintf("\t%s:%d +0x%x", file, line, pc-fn.Entry))
+		}
+	}
+	return lines
+}
+
+// symbolize replaces the lines of bare PCs in the stack trace with the
+// functions and file lines they're in.
+func (g *Goroutine) symbolize(s *Symbolizer) *Goroutine {
+	trace := g.origTrace
+	if trace == "" {
+		trace = g.trace
+	}
+	if !strings.Contains(trace, "0x") {
+		return g
+	}
+	ng, err := newGoroutine(g.header)
+	if err != nil {
+		// Not possible as the header is parsed before.
+		return g
+	}
+	ng.rewrites = g.rewrites
+	symbolized := false
+	for _, l := range strings.Split(strings.TrimSuffix(trace, "\n"), "\n") {
+		if !pcLinePattern.MatchString(l) {
+			ng.addLine(l)
+			continue
+		}
+		for _, sl := range s.lines(l) {
+			ng.addLine(sl)
+		}
+		symbolized = true
+	}
+	if !symbolized {
+		return g
+	}
+	ng.freeze()
+	ng.duplicates = g.duplicates
+	ng.delay, ng.contentions = g.delay, g.contentions
+	return ng
+}
+
+// Symbolize resolves the bare PCs in the stack traces by the line table of
+// the binary producing the dump, and returns the number of goroutines
+// symbolized.
+func (gd *GoroutineDump) Symbolize(s *Symbolizer) int {
+	changed := 0
+	for i, g := range gd.goroutines {
+		if ng := g.symbolize(s); ng != g {
+			changed++
+			gd.goroutines[i] = ng
+		}
+	}
+	return changed
+}
@@ -0,0 +1,108 @@
+package dump
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+// callers returns the PCs of its own frame and its callers.
+//
+//go:noinline
+func callers() []uintptr {
+	pcs := make([]uintptr, 2)
+	return pcs[:runtime.Callers(1, pcs)]
+}
+
+// testSymbolizer returns a symbolizer of the test binary itself.
+func testSymbolizer(t *testing.T) *Symbolizer {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("the test binary isn't an ELF binary")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSymbolizer(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSymbolize(t *testing.T) {
+	s := testSymbolizer(t)
+	pcs := callers()
+	if len(pcs) != 2 {
+		t.Fatalf("runtime.Callers() returned %d PCs", len(pcs))
+	}
+	gd := mustParse(t, fmt.Sprintf("goroutine 1 [running]:\n0x%x 0x%x\n\ngoroutine 2 [select]:\n0x10\n", pcs[0], pcs[1]))
+
+	if n := gd.Symbolize(s); n != 2 {
+		t.Errorf("Symbolize() = %d, want 2", n)
+	}
+	var fns []string
+	for _, f := range gd.Goroutines()[0].Frames() {
+		fns = append(fns, f.Func())
+		if !strings.HasSuffix(f.File(), "/dump/symbolize_test.go") {
+			t.Errorf("frame %s is in file %s", f.Func(), f.File())
+		}
+	}
+	pkg := "github.com/linuxerwang/goroutine-inspect/dump."
+	if want := []string{pkg + "callers", pkg + "TestSymbolize"}; strings.Join(fns, " ") != strings.Join(want, " ") {
+		t.Errorf("frames = %q, want %q", fns, want)
+	}
+
+	// PCs not in the binary are kept as they are.
+	if trace := gd.Goroutines()[1].Trace(); trace != "0x10\n" {
+		t.Errorf("trace of goroutine 2 = %q, want the PC", trace)
+	}
+	if notFound, total := s.NotFound(); notFound != 1 || total != 3 {
+		t.Errorf("NotFound() = %d, %d, want 1, 3", notFound, total)
+	}
+}
+
+func TestNewSymbolizerErrors(t *testing.T) {
+	if _, err := NewSymbolizer("symbolize_test.go"); err == nil {
+		t.Error("NewSymbolizer() succeeded with a source file")
+	}
+	if _, err := NewSymbolizer("no-such-binary"); err == nil {
+		t.Error("NewSymbolizer() succeeded with a missing file")
+	}
+}
+
+func TestProfileBuildID(t *testing.T) {
+	s := testSymbolizer(t)
+	pc := callers()[0]
+	m := &profile.Mapping{ID: 1, Start: 0x400000, Limit: 0x800000, BuildID: "7baeb99507065959"}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "goroutine", Unit: "count"}},
+		PeriodType: &profile.ValueType{Type: "goroutine", Unit: "count"},
+		Mapping:    []*profile.Mapping{m},
+		Location:   []*profile.Location{{ID: 1, Mapping: m, Address: uint64(pc)}},
+	}
+	p.Sample = []*profile.Sample{{Location: p.Location, Value: []int64{3}}}
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	gd := mustParse(t, buf.String())
+	if gd.BuildID() != m.BuildID || gd.Copy().BuildID() != m.BuildID {
+		t.Errorf("BuildID() = %q, want %q", gd.BuildID(), m.BuildID)
+	}
+	if n := gd.Symbolize(s); n != 3 {
+		t.Errorf("Symbolize() = %d, want 3", n)
+	}
+	for _, g := range gd.Goroutines() {
+		if f := g.Frames(); len(f) != 1 || !strings.HasSuffix(f[0].Func(), ".callers") {
+			t.Errorf("goroutine %d has trace %q, want callers()", g.ID(), g.Trace())
+		}
+	}
+}
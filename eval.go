@@ -0,0 +1,591 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Values of expressions are one of:
+//
+//	*GoroutineDump    a dump, e.g. x or x.copy()
+//	[]*GoroutineDump  a list of dumps, e.g. load_all("f")
+//	results           multiple dumps returned by a method, e.g. x.diff(y)
+//	string, int       literals and named conditions
+//	nil               methods printing something, e.g. x.show()
+
+// results are the dumps returned by a method with multiple results.
+type results []*GoroutineDump
+
+// method is a method callable on a dump.
+type method struct {
+	// inPlace is true if the method modifies the receiver and returns it for
+	// chaining, e.g. x.keep("...").dedup().
+	inPlace bool
+	call    func(gd *GoroutineDump, args []ast.Expr) (interface{}, error)
+}
+
+var (
+	// builtins are the functions which aren't called on a dump.
+	builtins map[string]func(args []ast.Expr) (interface{}, error)
+	methods  map[string]method
+
+	// keywords are the keyword arguments of the builtins, e.g. binary="f" of
+	// load(). Methods have none.
+	keywords = map[string][]string{"load": {"binary"}}
+)
+
+func init() {
+	// Initialized here as they refer to eval, which refers to them.
+	builtins = map[string]func(args []ast.Expr) (interface{}, error){
+		"extract": func(args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("extract", args, 1, 2); err != nil {
+				return nil, err
+			}
+			fn, err := evalString(args[0])
+			if err != nil {
+				return nil, err
+			}
+			prefix := ""
+			if len(args) == 2 {
+				if prefix, err = evalString(args[1]); err != nil {
+					return nil, err
+				}
+			}
+			return extract(fn, prefix)
+		},
+		"load": func(args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("load", args, 1, 2); err != nil {
+				return nil, err
+			}
+			fn, err := evalString(args[0])
+			if err != nil {
+				return nil, err
+			}
+			if len(args) == 1 {
+				return load(fn)
+			}
+			kv, ok := args[1].(*ast.KeyValueExpr)
+			if !ok || types.ExprString(kv.Key) != "binary" {
+				return nil, errors.New("load() expects the binary as binary=\"<binary-file-name>\"")
+			}
+			binary, err := evalString(kv.Value)
+			if err != nil {
+				return nil, err
+			}
+			return loadSymbolized(fn, binary)
+		},
+		"load_all": func(args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("load_all", args, 1, 1); err != nil {
+				return nil, err
+			}
+			fn, err := evalString(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return loadAll(fn)
+		},
+	}
+
+	methods = map[string]method{
+		"annotate": {inPlace: true, call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("annotate", args, 1, 1); err != nil {
+				return nil, err
+			}
+			fn, err := evalString(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return gd, gd.Annotate(fn)
+		}},
+		"channels": {call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("channels", args, 0, 0); err != nil {
+				return nil, err
+			}
+			gd.Channels()
+			return nil, nil
+		}},
+		"check": {call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("check", args, 1, 1); err != nil {
+				return nil, err
+			}
+			fn, err := evalString(args[0])
+			if err != nil {
+				return nil, err
+			}
+			_, err = gd.Check(fn)
+			return nil, err
+		}},
+		"copy": {call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("copy", args, 0, 1); err != nil {
+				return nil, err
+			}
+			cond := ""
+			if len(args) == 1 {
+				var err error
+				if cond, err = condArg(args[0]); err != nil {
+					return nil, err
+				}
+			}
+			return gd.Copy(cond)
+		}},
+		"dedup": {inPlace: true, call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("dedup", args, 0, 1); err != nil {
+				return nil, err
+			}
+			mode := settings.DedupMode
+			if len(args) == 1 {
+				var err error
+				if mode, err = evalString(args[0]); err != nil {
+					return nil, err
+				}
+			}
+			return gd, gd.Dedup(mode)
+		}},
+		"delete": {inPlace: true, call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("delete", args, 1, 1); err != nil {
+				return nil, err
+			}
+			cond, err := condArg(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return gd, gd.Delete(cond)
+		}},
+		"diff": {call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("diff", args, 1, 1); err != nil {
+				return nil, err
+			}
+			another, err := evalDump(args[0])
+			if err != nil {
+				return nil, err
+			}
+			lonly, common, ronly := gd.Diff(another)
+			return results{lonly, common, ronly}, nil
+		}},
+		"intersect": setOperation("intersect", (*GoroutineDump).Intersect),
+		"keep": {inPlace: true, call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("keep", args, 1, 1); err != nil {
+				return nil, err
+			}
+			cond, err := condArg(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return gd, gd.Keep(cond)
+		}},
+		"locks": {call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("locks", args, 0, 0); err != nil {
+				return nil, err
+			}
+			gd.Locks()
+			return nil, nil
+		}},
+		"packages": {call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("packages", args, 0, 0); err != nil {
+				return nil, err
+			}
+			gd.Packages()
+			return nil, nil
+		}},
+		"restore_paths": {inPlace: true, call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("restore_paths", args, 0, 0); err != nil {
+				return nil, err
+			}
+			gd.RestorePaths()
+			return gd, nil
+		}},
+		"rewrite_paths": {inPlace: true, call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("rewrite_paths", args, 0, 0); err != nil {
+				return nil, err
+			}
+			gd.RewritePaths()
+			return gd, nil
+		}},
+		"save": {call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("save", args, 1, 1); err != nil {
+				return nil, err
+			}
+			fn, err := evalString(args[0])
+			if err != nil {
+				return nil, err
+			}
+			if ok, err := confirmOverwrite(fn); err != nil || !ok {
+				return nil, err
+			}
+			if err := gd.Save(fn); err != nil {
+				return nil, err
+			}
+			fmt.Printf("Goroutines are saved to file %s.\n", fn)
+			return nil, nil
+		}},
+		"save_baseline": {call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("save_baseline", args, 1, 1); err != nil {
+				return nil, err
+			}
+			fn, err := evalString(args[0])
+			if err != nil {
+				return nil, err
+			}
+			if ok, err := confirmOverwrite(fn); err != nil || !ok {
+				return nil, err
+			}
+			if err := gd.SaveBaseline(fn); err != nil {
+				return nil, err
+			}
+			fmt.Printf("Baseline is saved to file %s.\n", fn)
+			return nil, nil
+		}},
+		"search": {call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("search", args, 1, 3); err != nil {
+				return nil, err
+			}
+			cond, err := condArg(args[0])
+			if err != nil {
+				return nil, err
+			}
+			offset, limit, err := rangeArgs(args[1:])
+			if err != nil {
+				return nil, err
+			}
+			gd.Search(cond, offset, limit)
+			return nil, nil
+		}},
+		"show": {call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("show", args, 0, 2); err != nil {
+				return nil, err
+			}
+			offset, limit, err := rangeArgs(args)
+			if err != nil {
+				return nil, err
+			}
+			gd.Show(offset, limit)
+			return nil, nil
+		}},
+		"sort": {inPlace: true, call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("sort", args, 1, 1); err != nil {
+				return nil, err
+			}
+			spec, err := evalString(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return gd, gd.Sort(spec)
+		}},
+		"source": {call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("source", args, 2, 3); err != nil {
+				return nil, err
+			}
+			nums := []int{0, 0, 5}
+			for i, arg := range args {
+				n, err := evalInt(arg)
+				if err != nil {
+					return nil, err
+				}
+				nums[i] = n
+			}
+			if nums[2] < 0 {
+				return nil, errors.New("'context' should not be negative")
+			}
+			return nil, gd.Source(nums[0], nums[1], nums[2])
+		}},
+		"subtract": setOperation("subtract", (*GoroutineDump).Subtract),
+		"summary": {call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("summary", args, 0, 1); err != nil {
+				return nil, err
+			}
+			key := ""
+			if len(args) == 1 {
+				var err error
+				if key, err = evalString(args[0]); err != nil {
+					return nil, err
+				}
+			}
+			gd.Summary()
+			if key != "" {
+				gd.LabelSummary(key)
+			}
+			return nil, nil
+		}},
+		"suppress": {inPlace: true, call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("suppress", args, 1, 1); err != nil {
+				return nil, err
+			}
+			fn, err := evalString(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return gd, gd.Suppress(fn)
+		}},
+		"suspects": {call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+			if err := checkArgs("suspects", args, 0, 0); err != nil {
+				return nil, err
+			}
+			gd.Suspects()
+			return nil, nil
+		}},
+		"union": setOperation("union", (*GoroutineDump).Union),
+	}
+}
+
+// setOperation returns the method of a set operation between two dumps, which
+// takes the other dump and optionally the matching key, see matchKey.
+func setOperation(name string, op func(gd, another *GoroutineDump, key string) (*GoroutineDump, error)) method {
+	return method{call: func(gd *GoroutineDump, args []ast.Expr) (interface{}, error) {
+		if err := checkArgs(name, args, 1, 2); err != nil {
+			return nil, err
+		}
+		another, err := evalDump(args[0])
+		if err != nil {
+			return nil, err
+		}
+		key := "id"
+		if len(args) == 2 {
+			if key, err = evalString(args[1]); err != nil {
+				return nil, err
+			}
+		}
+		return op(gd, another, key)
+	}}
+}
+
+// confirmOverwrite asks whether to overwrite a file if it exists.
+func confirmOverwrite(fn string) (bool, error) {
+	if _, err := os.Stat(fn); err != nil {
+		return true, nil
+	}
+	pmpt := fmt.Sprintf("File %s already exists, overwrite it? [Y]/n: ", fn)
+	confirm, err := line.Prompt(pmpt)
+	if err != nil {
+		return false, err
+	}
+	confirm = strings.ToLower(strings.TrimSpace(confirm))
+	return confirm == "y" || confirm == "", nil
+}
+
+// eval evaluates an expression of the statement language. Expressions which
+// aren't part of the language are reported as errors.
+func eval(e ast.Expr) (interface{}, error) {
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return eval(e.X)
+	case *ast.BasicLit:
+		switch e.Kind {
+		case token.STRING:
+			s, err := strconv.Unquote(e.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", e.Value)
+			}
+			return s, nil
+		case token.INT:
+			n, err := strconv.Atoi(e.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid integer %s", e.Value)
+			}
+			return n, nil
+		}
+		return nil, fmt.Errorf("unsupported literal %s", e.Value)
+	case *ast.UnaryExpr:
+		if e.Op == token.SUB {
+			if v, err := eval(e.X); err == nil {
+				if n, ok := v.(int); ok {
+					return -n, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("unsupported expression %s", types.ExprString(e))
+	case *ast.Ident:
+		if v, ok := workspace[e.Name]; ok {
+			return v, nil
+		}
+		if gds, ok := snapshots[e.Name]; ok {
+			return gds, nil
+		}
+		if cond, ok := macros[e.Name]; ok {
+			return cond, nil
+		}
+		return nil, fmt.Errorf("variable %s not found in workspace", e.Name)
+	case *ast.IndexExpr:
+		v, err := eval(e.X)
+		if err != nil {
+			return nil, err
+		}
+		gds, ok := v.([]*GoroutineDump)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a list of dumps", types.ExprString(e.X))
+		}
+		idx, err := evalInt(e.Index)
+		if err != nil {
+			return nil, fmt.Errorf("index of %s should be an integer", types.ExprString(e.X))
+		}
+		if idx < 0 || idx >= len(gds) {
+			return nil, fmt.Errorf("index %d out of range [0, %d)", idx, len(gds))
+		}
+		return gds[idx], nil
+	case *ast.CallExpr:
+		if e.Ellipsis.IsValid() {
+			return nil, fmt.Errorf("unsupported expression %s", types.ExprString(e))
+		}
+		if err := checkKeywords(e); err != nil {
+			return nil, err
+		}
+		switch fun := e.Fun.(type) {
+		case *ast.Ident:
+			builtin, ok := builtins[fun.Name]
+			if !ok {
+				return nil, fmt.Errorf("unknown function %s()", fun.Name)
+			}
+			return builtin(e.Args)
+		case *ast.SelectorExpr:
+			m, ok := methods[fun.Sel.Name]
+			if !ok {
+				return nil, fmt.Errorf("unknown method %s()", fun.Sel.Name)
+			}
+			gd, err := evalDump(fun.X)
+			if err != nil {
+				return nil, err
+			}
+			return m.call(gd, e.Args)
+		}
+		return nil, fmt.Errorf("unsupported call %s", types.ExprString(e))
+	case *ast.SelectorExpr:
+		return nil, fmt.Errorf("%s is a method, call it with %s()", types.ExprString(e), types.ExprString(e))
+	case *ast.KeyValueExpr:
+		return nil, fmt.Errorf("unexpected argument %s=", types.ExprString(e.Key))
+	}
+	return nil, fmt.Errorf("unsupported expression %s", types.ExprString(e))
+}
+
+// evalDump evaluates an expression which should be a dump.
+func evalDump(e ast.Expr) (*GoroutineDump, error) {
+	v, err := eval(e)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case *GoroutineDump:
+		return v, nil
+	case []*GoroutineDump:
+		return nil, fmt.Errorf("%s is a list of dumps, use %s[<index>]", types.ExprString(e), types.ExprString(e))
+	case results:
+		return nil, fmt.Errorf("%s returns %d dumps, assign them to variables first", types.ExprString(e), len(v))
+	}
+	return nil, fmt.Errorf("%s is not a dump", types.ExprString(e))
+}
+
+// evalString evaluates an expression which should be a string.
+func evalString(e ast.Expr) (string, error) {
+	v, err := eval(e)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s is not a string", types.ExprString(e))
+	}
+	return s, nil
+}
+
+// evalInt evaluates an expression which should be an integer.
+func evalInt(e ast.Expr) (int, error) {
+	v, err := eval(e)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int)
+	if !ok {
+		return 0, fmt.Errorf("%s is not an integer", types.ExprString(e))
+	}
+	return n, nil
+}
+
+// checkKeywords checks that the keyword arguments of a call are known.
+func checkKeywords(call *ast.CallExpr) error {
+	name := ""
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		name = fun.Name
+	case *ast.SelectorExpr:
+		name = fun.Sel.Name
+	}
+	for _, arg := range call.Args {
+		kv, ok := arg.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+		key := types.ExprString(kv.Key)
+		known := false
+		if _, ok := call.Fun.(*ast.Ident); ok {
+			for _, k := range keywords[name] {
+				known = known || k == key
+			}
+		}
+		if !known {
+			return fmt.Errorf("%s() has no argument %s", name, key)
+		}
+	}
+	return nil
+}
+
+// checkArgs checks that a function is called with min to max arguments.
+func checkArgs(fn string, args []ast.Expr, min, max int) error {
+	if len(args) >= min && len(args) <= max {
+		return nil
+	}
+	switch {
+	case max == 0:
+		return fmt.Errorf("%s() expects no arguments", fn)
+	case min == max && max == 1:
+		return fmt.Errorf("%s() expects exactly one argument", fn)
+	case min == max:
+		return fmt.Errorf("%s() expects exactly %d arguments", fn, max)
+	}
+	return fmt.Errorf("%s() expects %d to %d arguments", fn, min, max)
+}
+
+// rangeArgs evaluates the optional offset and limit arguments of show() and
+// search().
+func rangeArgs(args []ast.Expr) (int, int, error) {
+	offset, limit := 0, settings.ShowLimit
+	var err error
+	if len(args) >= 1 {
+		if offset, err = evalInt(args[0]); err != nil {
+			return 0, 0, fmt.Errorf("invalid argument 'offset' %s", types.ExprString(args[0]))
+		}
+		if offset < 0 {
+			return 0, 0, errors.New("'offset' should not be negative")
+		}
+	}
+	if len(args) == 2 {
+		if limit, err = evalInt(args[1]); err != nil {
+			return 0, 0, fmt.Errorf("invalid argument 'limit' %s", types.ExprString(args[1]))
+		}
+	}
+	return offset, limit, nil
+}
+
+// isBuiltinCall tells if e is a call of a builtin function, e.g. load().
+func isBuiltinCall(e ast.Expr) bool {
+	if call, ok := e.(*ast.CallExpr); ok {
+		if fun, ok := call.Fun.(*ast.Ident); ok {
+			_, ok := builtins[fun.Name]
+			return ok
+		}
+	}
+	return false
+}
+
+// isInPlaceCall tells if e is a call of a method modifying its receiver.
+func isInPlaceCall(e ast.Expr) bool {
+	if call, ok := e.(*ast.CallExpr); ok {
+		if fun, ok := call.Fun.(*ast.SelectorExpr); ok {
+			return methods[fun.Sel.Name].inPlace
+		}
+	}
+	return false
+}
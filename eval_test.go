@@ -0,0 +1,190 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/linuxerwang/goroutine-inspect/dump"
+)
+
+// varIds returns the goroutine ids of a variable in the workspace.
+func varIds(t *testing.T, name string) []int {
+	t.Helper()
+	gd, ok := workspace[name]
+	if !ok {
+		t.Fatalf("variable %s not found", name)
+	}
+	ids := []int{}
+	for _, g := range gd.Goroutines() {
+		ids = append(ids, g.ID())
+	}
+	return ids
+}
+
+func TestEvalChaining(t *testing.T) {
+	newShell(t)
+	workspace["x"] = mustLoad(t, sampleDump)
+
+	out := run(t,
+		`y = x.copy("state == 'chan receive'").dedup().sort("id desc")`,
+		`x.keep("id > 1").sort("duration")`,
+	)
+	if strings.Contains(out, "Error") {
+		t.Fatalf("unexpected error:\n%s", out)
+	}
+	if got, want := varIds(t, "y"), []int{7, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("y = %v, want %v", got, want)
+	}
+	if got, want := varIds(t, "x"), []int{7, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("x = %v, want %v", got, want)
+	}
+}
+
+func TestEvalAssignCopies(t *testing.T) {
+	newShell(t)
+	workspace["x"] = mustLoad(t, sampleDump)
+
+	run(t, `y = x`, `y.dedup()`, `z = y.keep("id > 5")`)
+	if got, want := varIds(t, "x"), []int{1, 5, 6, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("x = %v, want %v", got, want)
+	}
+	if got, want := varIds(t, "y"), []int{7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("y = %v, want %v", got, want)
+	}
+	if workspace["y"] == workspace["z"] {
+		t.Error("y and z share a dump")
+	}
+}
+
+func TestEvalDiff(t *testing.T) {
+	newShell(t)
+	workspace["x"] = mustLoad(t, sampleDump)
+	workspace["y"] = mustLoad(t, strings.Replace(sampleDump, "goroutine 7 ", "goroutine 8 ", 1))
+
+	run(t, `l, c, r = x.diff(y)`)
+	for name, want := range map[string][]int{"l": {7}, "r": {8}} {
+		if got := varIds(t, name); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	if n := len(workspace["c"].Goroutines()); n != 3 {
+		t.Errorf("c has %d goroutines, want 3", n)
+	}
+
+	out := run(t, `x.diff(y)`)
+	if strings.Count(out, "# of goroutines") != 3 {
+		t.Errorf("x.diff(y) printed:\n%s", out)
+	}
+}
+
+func TestEvalLoad(t *testing.T) {
+	newShell(t)
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "dump.txt")
+	if err := os.WriteFile(fn, []byte(sampleDump+"\nSIGQUIT: quit\n\n"+strings.Replace(sampleDump, "goroutine 1 [running]", "goroutine 2 [running]", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := run(t,
+		`all = load_all("`+fn+`")`,
+		`second = all[1]`,
+		`whos`,
+	)
+	if !strings.Contains(out, "# of dumps: 2") || !strings.Contains(out, "all[0..1]\tsecond") {
+		t.Errorf("load_all printed:\n%s", out)
+	}
+	if got := varIds(t, "second"); got[0] != 2 {
+		t.Errorf("second = %v, want goroutine 2 first", got)
+	}
+
+	out = run(t, `x = load("`+fn+`")`)
+	if !strings.Contains(out, "# of goroutines: 8") {
+		t.Errorf("load printed:\n%s", out)
+	}
+}
+
+func TestEvalLoadBinary(t *testing.T) {
+	newShell(t)
+	if runtime.GOOS != "linux" {
+		t.Skip("the test binary isn't an ELF binary")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := dump.NewSymbolizer(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fn := filepath.Join(t.TempDir(), "dump.txt")
+	if err := os.WriteFile(fn, []byte("goroutine 1 [running]:\n0x10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := run(t, `x = load("`+fn+`", binary = "`+exe+`")`)
+	if want := "Warning: 1 of 1 PCs not found in " + exe; !strings.Contains(out, want) {
+		t.Errorf("load() printed:\n%s\nwant %q", out, want)
+	}
+
+	m := &profile.Mapping{ID: 1, BuildID: "0123"}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "goroutine", Unit: "count"}},
+		Mapping:    []*profile.Mapping{m},
+		Location:   []*profile.Location{{ID: 1, Mapping: m, Address: 0x10}},
+	}
+	p.Sample = []*profile.Sample{{Location: p.Location, Value: []int64{1}}}
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Write(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	out = run(t, `x = load("`+fn+`", binary="`+exe+`")`)
+	if want := "Warning: the build ID of " + exe + " is " + s.BuildID() + ", but the dump is from build ID 0123."; s.BuildID() != "" && !strings.Contains(out, want) {
+		t.Errorf("load() printed:\n%s\nwant %q", out, want)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	newShell(t)
+	workspace["x"] = mustLoad(t, sampleDump)
+	snapshots["all"] = []*GoroutineDump{workspace["x"]}
+
+	for stmt, want := range map[string]string{
+		`nope`:                   "variable nope not found in workspace",
+		`x.nope()`:               "unknown method nope()",
+		`nope()`:                 "unknown function nope()",
+		`x.dedup`:                "x.dedup is a method, call it with x.dedup()",
+		`x.show("1")`:            `invalid argument 'offset' "1"`,
+		`x.show(-1)`:             "'offset' should not be negative",
+		`x.show(1, 2, 3)`:        "show() expects 0 to 2 arguments",
+		`x.diff(all)`:            "all is a list of dumps, use all[<index>]",
+		`x.diff(x.diff(x))`:      "x.diff(x) returns 3 dumps, assign them to variables first",
+		`x.diff("x")`:            `"x" is not a dump`,
+		`all[1]`:                 "index 1 out of range [0, 1)",
+		`x[0]`:                   "x is not a list of dumps",
+		`a, b = x.copy()`:        "x.copy() returns 1 dump, got 2 variables",
+		`a, b, c, d = x.diff(x)`: "x.diff(x) returns at most 3 dumps, got 4 variables",
+		`a = x.show()`:           "x.show() returns no value",
+		`1a = x`:                 "Error",
+		`x.keep("id > 1"`:        "Error",
+		`x + 1`:                  "unsupported expression x + 1",
+		`x.show(offset=1)`:       "show() has no argument offset",
+		`load("f", bin="b")`:     "load() has no argument bin",
+		`load("f", "b")`:         `load() expects the binary as binary="<binary-file-name>"`,
+	} {
+		if out := run(t, stmt); !strings.Contains(out, want) {
+			t.Errorf("%s printed %q, want %q", stmt, out, want)
+		}
+	}
+	if got, want := varIds(t, "x"), []int{1, 5, 6, 7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("x = %v after the errors, want %v", got, want)
+	}
+}
@@ -1,132 +1,87 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
-	"os"
-	"strconv"
-	"strings"
+	"go/scanner"
+	"go/token"
 )
 
+// parseExpr parses an expression of the statement language. Besides Go
+// expressions, calls may have keyword arguments, e.g.
+// load("f", binary="./server"), which are parsed into *ast.KeyValueExpr.
+func parseExpr(e string) (ast.Expr, error) {
+	// Go has no keyword arguments, so the "=" of each one is replaced with
+	// "<" of the same length to parse, and the comparison is turned into a
+	// key-value pair after.
+	src := []byte(e)
+	file := token.NewFileSet().AddFile("", -1, len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, 0)
+	keywords := map[int]bool{} // By the offset of "=".
+	var prev, prev2 token.Token
+	for {
+		pos, tok, _ := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.ASSIGN && prev == token.IDENT && (prev2 == token.LPAREN || prev2 == token.COMMA) {
+			keywords[file.Offset(pos)] = true
+			src[file.Offset(pos)] = '<'
+		}
+		prev, prev2 = tok, prev
+	}
+
+	fset := token.NewFileSet()
+	ex, err := parser.ParseExprFrom(fset, "", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	ast.Inspect(ex, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			for i, arg := range call.Args {
+				if b, ok := arg.(*ast.BinaryExpr); ok && keywords[fset.Position(b.OpPos).Offset] {
+					call.Args[i] = &ast.KeyValueExpr{Key: b.X, Colon: b.OpPos, Value: b.Y}
+				}
+			}
+		}
+		return true
+	})
+	return ex, nil
+}
+
+// expr evaluates an expression statement and prints its value. Methods which
+// modify their receiver, e.g. x.dedup(), print nothing more.
 func expr(e string) error {
-	ex, err := parser.ParseExpr(e)
+	ex, err := parseExpr(e)
+	if err != nil {
+		return err
+	}
+	v, err := eval(ex)
 	if err != nil {
 		return err
 	}
+	if isInPlaceCall(ex) {
+		return nil
+	}
 
-	switch ex := ex.(type) {
-	case *ast.CallExpr:
-		switch fun := ex.Fun.(type) {
-		case *ast.SelectorExpr:
-			k := fun.X.(*ast.Ident).Name
-			if v, ok := workspace[k]; ok {
-				switch fun.Sel.Name {
-				case "delete":
-					if len(ex.Args) != 1 {
-						return errors.New("keep() expects exactly one argument")
-					}
-					return v.Delete(ex.Args[0].(*ast.BasicLit).Value)
-				case "dedup":
-					if len(ex.Args) != 0 {
-						return errors.New("dedup() expects no arguments")
-					}
-					v.Dedup()
-					return nil
-				case "keep":
-					if len(ex.Args) != 1 {
-						return errors.New("delete() expects exactly one argument")
-					}
-					return v.Keep(ex.Args[0].(*ast.BasicLit).Value)
-				case "save":
-					if len(ex.Args) != 1 {
-						return errors.New("save() expects exactly one argument")
-					}
-					fn := strings.Trim(ex.Args[0].(*ast.BasicLit).Value, "\"")
-					if _, err := os.Stat(fn); err == nil {
-						pmpt := fmt.Sprintf("File %s already exists, overwrite it? [Y]/n: ", fn)
-						var confirm string
-						if confirm, err = line.Prompt(pmpt); err != nil {
-							return err
-						}
-						confirm = strings.ToLower(strings.TrimSpace(confirm))
-						if confirm != "y" && confirm != "" {
-							return nil
-						}
-					}
-					if err := v.Save(fn); err != nil {
-						return err
-					}
-					fmt.Printf("Goroutines are saved to file %s.\n", fn)
-				case "search":
-					var err error
-					offset := 0
-					limit := 10
-					switch len(ex.Args) {
-					case 0:
-						return errors.New("search() expects at least one argument")
-					case 1:
-					case 2:
-						offset, err = strconv.Atoi(ex.Args[1].(*ast.BasicLit).Value)
-						if err != nil {
-							return fmt.Errorf("invalid argument %s", ex.Args[1])
-						}
-					case 3:
-						offset, err = strconv.Atoi(ex.Args[1].(*ast.BasicLit).Value)
-						if err != nil {
-							return fmt.Errorf("invalid argument 'offset' %s", ex.Args[1])
-						}
-						limit, err = strconv.Atoi(ex.Args[2].(*ast.BasicLit).Value)
-						if err != nil {
-							return fmt.Errorf("invalid argument 'limit' %s", ex.Args[2])
-						}
-					default:
-						return errors.New("search() expects at most three arguments")
-					}
-					v.Search(ex.Args[0].(*ast.BasicLit).Value, offset, limit)
-					return nil
-				case "show":
-					var err error
-					offset := 0
-					limit := 10
-					switch len(ex.Args) {
-					case 0:
-					case 1:
-						offset, err = strconv.Atoi(ex.Args[0].(*ast.BasicLit).Value)
-						if err != nil {
-							return fmt.Errorf("invalid argument %s", ex.Args[0])
-						}
-					case 2:
-						offset, err = strconv.Atoi(ex.Args[0].(*ast.BasicLit).Value)
-						if err != nil {
-							return fmt.Errorf("invalid argument 'offset' %s", ex.Args[0])
-						}
-						limit, err = strconv.Atoi(ex.Args[1].(*ast.BasicLit).Value)
-						if err != nil {
-							return fmt.Errorf("invalid argument 'limit' %s", ex.Args[1])
-						}
-					default:
-						return errors.New("show() expects at least one and at most two arguments")
-					}
-					v.Show(offset, limit)
-					return nil
-				default:
-					return fmt.Errorf("unknown instrution")
-				}
-			}
-		default:
-			return fmt.Errorf("unknown instrution")
+	switch v := v.(type) {
+	case *GoroutineDump:
+		v.Summary()
+	case []*GoroutineDump:
+		name := ""
+		if id, ok := ex.(*ast.Ident); ok {
+			name = id.Name
 		}
-	case *ast.Ident:
-		if v, ok := workspace[ex.String()]; ok {
-			v.Summary()
-		} else {
-			return fmt.Errorf("variable %s not found in workspace", e)
+		printSnapshots(name, v)
+	case results:
+		for _, gd := range v {
+			gd.Summary()
 		}
+	case nil:
 	default:
-		return fmt.Errorf("unknown instrution")
+		fmt.Println(v)
 	}
-
 	return nil
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/linuxerwang/goroutine-inspect/dump"
+)
+
+// isOwnPackage tells if pkg belongs to our own code, as configured by the
+// own_modules setting.
+func isOwnPackage(pkg string) bool {
+	if len(settings.OwnModules) == 0 {
+		return pkg != "" && !dump.IsStdPackage(pkg)
+	}
+	for _, m := range settings.OwnModules {
+		if dump.InPackage(pkg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHiddenFrame tells if f is collapsed in compact mode.
+func isHiddenFrame(f *dump.Frame) bool {
+	if dump.IsStdPackage(f.Package()) {
+		return true
+	}
+	for _, p := range settings.HideFrames {
+		if strings.HasPrefix(f.Func(), p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+module github.com/linuxerwang/goroutine-inspect
+
+go 1.25.0
+
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/Knetic/govaluate v3.0.0+incompatible
+	github.com/google/pprof v0.0.0-20260906184651-6331bc6350fe
+	github.com/peterh/liner v1.2.2
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/mattn/go-runewidth v0.0.3 // indirect
+	golang.org/x/sys v0.32.0 // indirect
+)
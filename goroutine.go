@@ -1,426 +1,312 @@
 package main
 
 import (
-	"bytes"
-	"crypto/md5"
-	"errors"
 	"fmt"
-	"hash"
-	"io"
-	"regexp"
+	"os"
 	"sort"
-	"strconv"
 	"strings"
+	"time"
 
-	"os"
-
-	"github.com/Knetic/govaluate"
-	"github.com/foize/go.sgr"
-)
-
-type MetaType int
-
-var (
-	MetaState    MetaType = 0
-	MetaDuration MetaType = 1
-
-	durationPattern = regexp.MustCompile(`^\d+ minutes$`)
-
-	functions = map[string]govaluate.ExpressionFunction{
-		"contains": func(args ...interface{}) (interface{}, error) {
-			if len(args) != 2 {
-				return nil, fmt.Errorf("contains() accepts exactly two arguments")
-			}
-			idx := strings.Index(args[0].(string), args[1].(string))
-			return bool(idx > -1), nil
-		},
-		"lower": func(args ...interface{}) (interface{}, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("lower() accepts exactly one arguments")
-			}
-			lowered := strings.ToLower(args[0].(string))
-			return string(lowered), nil
-		},
-		"upper": func(args ...interface{}) (interface{}, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("upper() accepts exactly one arguments")
-			}
-			uppered := strings.ToUpper(args[0].(string))
-			return string(uppered), nil
-		},
-	}
+	"github.com/linuxerwang/goroutine-inspect/dump"
 )
 
-// Goroutine contains a goroutine info.
-type Goroutine struct {
-	id       int
-	header   string
-	trace    string
-	lines    int
-	duration int // In minutes.
-	metas    map[MetaType]string
-
-	lineMd5    []string
-	fullMd5    string
-	fullHasher hash.Hash
-	duplicates []int
-
-	frozen bool
-	buf    *bytes.Buffer
-}
-
-// AddLine appends a line to the goroutine info.
-func (g *Goroutine) AddLine(l string) {
-	if !g.frozen {
-		g.lines++
-		g.buf.WriteString(l)
-		g.buf.WriteString("\n")
-
-		if strings.HasPrefix(l, "\t") {
-			parts := strings.Split(l, " ")
-			fl := strings.TrimSpace(parts[0])
-
-			h := md5.New()
-			io.WriteString(h, fl)
-			g.lineMd5 = append(g.lineMd5, string(h.Sum(nil)))
-
-			io.WriteString(g.fullHasher, fl)
-		}
-	}
-}
-
-// Freeze freezes the goroutine info.
-func (g *Goroutine) Freeze() {
-	if !g.frozen {
-		g.frozen = true
-		g.trace = g.buf.String()
-		g.buf = nil
-
-		g.fullMd5 = string(g.fullHasher.Sum(nil))
-	}
+// GoroutineDump is a dump in the workspace, with the methods of the
+// interactive shell printing their results.
+type GoroutineDump struct {
+	*dump.GoroutineDump
 }
 
-// Print outputs the goroutine details to w.
-func (g Goroutine) Print(w io.Writer) error {
-	if _, err := fmt.Fprint(w, g.header); err != nil {
-		return err
-	}
-	if len(g.duplicates) > 0 {
-		if _, err := fmt.Fprintf(w, " %d times: [[", len(g.duplicates)); err != nil {
-			return err
-		}
-		for i, id := range g.duplicates {
+// printGoroutine outputs the goroutine details to stdout with color, with the
+// ids of the duplicates if any.
+func printGoroutine(g *dump.Goroutine, dups []int) {
+	colorPrintf("[fg-blue]%s[reset]", g.Header())
+	if len(dups) > 0 {
+		colorPrintf(" [fg-red]%d[reset] times: [[", len(dups))
+		for i, id := range dups {
 			if i > 0 {
-				if _, err := fmt.Fprint(w, ", "); err != nil {
-					return err
-				}
+				colorPrintf(", ")
 			}
-			if _, err := fmt.Fprint(w, id); err != nil {
-				return err
-			}
-		}
-		if _, err := fmt.Fprint(w, "]"); err != nil {
-			return err
+			colorPrintf("[fg-green]%d[reset]", id)
 		}
+		colorPrintf("]")
 	}
-	if _, err := fmt.Fprintln(w); err != nil {
-		return err
+	if delay, contentions := g.Delay(); delay > 0 {
+		colorPrintf(", delayed [fg-red]%s[reset] in %d contention(s)", delay.Round(time.Millisecond).String(), contentions)
 	}
-	if _, err := fmt.Fprintln(w, g.trace); err != nil {
-		return err
+	fmt.Println()
+	if settings.Compact {
+		printCompact(g)
+	} else {
+		fmt.Println(g.Trace())
 	}
-	return nil
 }
 
-// PrintWithColor outputs the goroutine details to stdout with color.
-func (g Goroutine) PrintWithColor() {
-	sgr.Printf("[fg-blue]%s[reset]", g.header)
-	if len(g.duplicates) > 0 {
-		sgr.Printf(" [fg-red]%d[reset] times: [[", len(g.duplicates))
-		for i, id := range g.duplicates {
-			if i > 0 {
-				sgr.Printf(", ")
-			}
-			sgr.Printf("[fg-green]%d[reset]", id)
+// printCompact prints the stack trace to stdout, collapsing consecutive
+// runtime, standard library and hide_frames frames into one line and
+// highlighting the first frame in our own code.
+func printCompact(g *dump.Goroutine) {
+	hidden := 0
+	flush := func() {
+		switch {
+		case hidden == 1:
+			colorPrintf("[fg-white]… 1 hidden frame[reset]\n")
+		case hidden > 1:
+			colorPrintf("[fg-white]… %d hidden frames[reset]\n", hidden)
 		}
-		sgr.Print("]")
-	}
-	sgr.Println()
-	fmt.Println(g.trace)
-}
-
-// NewGoroutine creates and returns a new Goroutine.
-func NewGoroutine(metaline string) (*Goroutine, error) {
-	idx := strings.Index(metaline, "[")
-	parts := strings.Split(metaline[idx+1:len(metaline)-2], ",")
-	metas := map[MetaType]string{
-		MetaState: strings.TrimSpace(parts[0]),
+		hidden = 0
 	}
 
-	duration := 0
-	if len(parts) > 1 {
-		value := strings.TrimSpace(parts[1])
-		metas[MetaDuration] = value
-		if durationPattern.MatchString(value) {
-			if d, err := strconv.Atoi(value[:len(value)-8]); err == nil {
-				duration = d
-			}
+	highlighted := false
+	frames := g.Frames()
+	lines := strings.Split(strings.TrimSuffix(g.Trace(), "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		// A frame is a function line followed by its file line.
+		j := i + 1
+		for j < len(lines) && strings.HasPrefix(lines[j], "\t") {
+			j++
+		}
+		frame := strings.Join(lines[i:j], "\n")
+		l := lines[i]
+		i = j - 1
+
+		// The other lines are the frames in order.
+		if strings.HasPrefix(l, "created by ") || strings.HasPrefix(l, "...") || strings.HasPrefix(l, "\t") || len(frames) == 0 {
+			flush()
+			fmt.Println(frame)
+			continue
+		}
+		f := frames[0]
+		frames = frames[1:]
+		if isHiddenFrame(f) {
+			hidden++
+			continue
+		}
+		flush()
+		if !highlighted && isOwnPackage(f.Package()) {
+			highlighted = true
+			colorPrintf("[fg-yellow]%s[reset]\n", frame)
+		} else {
+			fmt.Println(frame)
 		}
 	}
-
-	idstr := strings.TrimSpace(metaline[9:idx])
-	id, err := strconv.Atoi(idstr)
-	if err != nil {
-		return nil, err
-	}
-
-	return &Goroutine{
-		id:         id,
-		lines:      1,
-		header:     metaline,
-		buf:        &bytes.Buffer{},
-		duration:   duration,
-		metas:      metas,
-		fullHasher: md5.New(),
-		duplicates: []int{},
-	}, nil
-}
-
-// GoroutineDump defines a goroutine dump.
-type GoroutineDump struct {
-	goroutines []*Goroutine
-}
-
-// Add appends a goroutine info to the list.
-func (gd *GoroutineDump) Add(g *Goroutine) {
-	gd.goroutines = append(gd.goroutines, g)
+	flush()
+	fmt.Println()
 }
 
 // Copy duplicates and returns the GoroutineDump.
-func (gd GoroutineDump) Copy(cond string) *GoroutineDump {
-	dump := GoroutineDump{
-		goroutines: []*Goroutine{},
-	}
+func (gd *GoroutineDump) Copy(cond string) (*GoroutineDump, error) {
 	if cond == "" {
 		// Copy all.
-		for _, d := range gd.goroutines {
-			dump.goroutines = append(dump.goroutines, d)
-		}
-	} else {
-		goroutines, err := gd.withCondition(cond, func(i int, g *Goroutine, passed bool) *Goroutine {
-			if passed {
-				return g
-			}
-			return nil
-		})
-		if err != nil {
-			fmt.Println(err)
-			return nil
-		}
-		dump.goroutines = goroutines
+		return &GoroutineDump{gd.GoroutineDump.Copy()}, nil
 	}
-	return &dump
+	matched, err := gd.withCondition(cond, true)
+	if err != nil {
+		return nil, err
+	}
+	return &GoroutineDump{matched}, nil
 }
 
 // Dedup finds goroutines with duplicated stack traces and keeps only one copy
-// of them.
-func (gd *GoroutineDump) Dedup() {
-	m := map[string][]int{}
-	for _, g := range gd.goroutines {
-		if _, ok := m[g.fullMd5]; ok {
-			m[g.fullMd5] = append(m[g.fullMd5], g.id)
-		} else {
-			m[g.fullMd5] = []int{g.id}
-		}
-	}
-
-	kept := make([]*Goroutine, 0, len(gd.goroutines))
-
-outter:
-	for digest, ids := range m {
-		for _, g := range gd.goroutines {
-			if g.fullMd5 == digest {
-				g.duplicates = ids
-				kept = append(kept, g)
-				continue outter
-			}
-		}
+// of them. The mode decides how stack traces are compared, see
+// dump.DedupModes.
+func (gd *GoroutineDump) Dedup(mode string) error {
+	before := len(gd.Goroutines())
+	if err := gd.GoroutineDump.Dedup(mode); err != nil {
+		return err
 	}
-
-	if len(gd.goroutines) != len(kept) {
-		fmt.Printf("Dedupped %d, kept %d\n", len(gd.goroutines), len(kept))
-		gd.goroutines = kept
+	if kept := len(gd.Goroutines()); kept != before {
+		fmt.Printf("Dedupped %d, kept %d\n", before, kept)
 	}
+	return nil
 }
 
 // Delete deletes by the condition.
 func (gd *GoroutineDump) Delete(cond string) error {
-	goroutines, err := gd.withCondition(cond, func(i int, g *Goroutine, passed bool) *Goroutine {
-		if !passed {
-			return g
-		}
-		return nil
-	})
+	kept, err := gd.withCondition(cond, false)
+	if err != nil {
+		return err
+	}
+	gd.GoroutineDump = kept
+	return nil
+}
+
+// Keep keeps by the condition.
+func (gd *GoroutineDump) Keep(cond string) error {
+	kept, err := gd.withCondition(cond, true)
 	if err != nil {
 		return err
 	}
-	gd.goroutines = goroutines
+	gd.GoroutineDump = kept
 	return nil
 }
 
 // Diff shows the difference between two dumps.
 func (gd *GoroutineDump) Diff(another *GoroutineDump) (*GoroutineDump, *GoroutineDump, *GoroutineDump) {
-	lonly := map[int]*Goroutine{}
-	ronly := map[int]*Goroutine{}
-	common := map[int]*Goroutine{}
+	lonly, common, ronly := gd.GoroutineDump.Diff(another.GoroutineDump)
+	return &GoroutineDump{lonly}, &GoroutineDump{common}, &GoroutineDump{ronly}
+}
+
+// Union returns the goroutines in either dump, see dump.GoroutineDump.Union.
+func (gd *GoroutineDump) Union(another *GoroutineDump, key string) (*GoroutineDump, error) {
+	return wrap(gd.GoroutineDump.Union(another.GoroutineDump, key))
+}
+
+// Intersect returns the goroutines in gd matching one in another.
+func (gd *GoroutineDump) Intersect(another *GoroutineDump, key string) (*GoroutineDump, error) {
+	return wrap(gd.GoroutineDump.Intersect(another.GoroutineDump, key))
+}
 
-	for _, v := range gd.goroutines {
-		lonly[v.id] = v
+// Subtract returns the goroutines in gd matching none in another.
+func (gd *GoroutineDump) Subtract(another *GoroutineDump, key string) (*GoroutineDump, error) {
+	return wrap(gd.GoroutineDump.Subtract(another.GoroutineDump, key))
+}
+
+// wrap wraps the dump returned by an operation of the library.
+func wrap(gd *dump.GoroutineDump, err error) (*GoroutineDump, error) {
+	if err != nil {
+		return nil, err
 	}
-	for _, v := range another.goroutines {
-		if _, ok := lonly[v.id]; ok {
-			delete(lonly, v.id)
-			common[v.id] = v
-		} else {
-			ronly[v.id] = v
+	return &GoroutineDump{gd}, nil
+}
+
+// Packages prints the number of goroutines with each package anywhere in the
+// call stack, and at the top of the call stack.
+func (gd *GoroutineDump) Packages() {
+	anywhere := map[string]int{}
+	top := map[string]int{}
+	for _, g := range gd.Goroutines() {
+		for _, pkg := range g.Packages() {
+			anywhere[pkg]++
+		}
+		if frames := g.Frames(); len(frames) > 0 {
+			top[frames[0].Package()]++
 		}
 	}
-	return NewGoroutineDumpFromMap(lonly), NewGoroutineDumpFromMap(common), NewGoroutineDumpFromMap(ronly)
-}
 
-// Keep keeps by the condition.
-func (gd *GoroutineDump) Keep(cond string) error {
-	goroutines, err := gd.withCondition(cond, func(i int, g *Goroutine, passed bool) *Goroutine {
-		if passed {
-			return g
+	pkgs := make([]string, 0, len(anywhere))
+	for pkg := range anywhere {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		if anywhere[pkgs[i]] != anywhere[pkgs[j]] {
+			return anywhere[pkgs[i]] > anywhere[pkgs[j]]
 		}
-		return nil
+		return pkgs[i] < pkgs[j]
 	})
-	if err != nil {
-		return err
+
+	fmt.Printf("%10s %10s  %s\n", "anywhere", "top", "package")
+	for _, pkg := range pkgs {
+		fmt.Printf("%10d %10d  %s\n", anywhere[pkg], top[pkg], pkg)
 	}
-	gd.goroutines = goroutines
-	return nil
+	fmt.Println()
 }
 
 // Save saves the goroutine dump to the given file.
-func (gd GoroutineDump) Save(fn string) error {
+func (gd *GoroutineDump) Save(fn string) error {
 	f, err := os.Create(fn)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-
-	for _, g := range gd.goroutines {
-		if err := g.Print(f); err != nil {
-			return err
-		}
-	}
-	return nil
+	return gd.Write(f)
 }
 
 // Search displays the goroutines with the offset and limit.
-func (gd GoroutineDump) Search(cond string, offset, limit int) {
-	sgr.Printf("[fg-green]Search with offset %d and limit %d.[reset]\n\n", offset, limit)
+func (gd *GoroutineDump) Search(cond string, offset, limit int) {
+	colorPrintf("[fg-green]Search with offset %d and limit %d.[reset]\n\n", offset, limit)
 
-	count := 0
-	_, err := gd.withCondition(cond, func(i int, g *Goroutine, passed bool) *Goroutine {
-		if passed {
-			if count >= offset && count < offset+limit {
-				g.PrintWithColor()
-			}
-			count++
-		}
-		return nil
-	})
+	matched, err := gd.filter(cond)
 	if err != nil {
 		fmt.Println(err)
+		return
 	}
+	(&GoroutineDump{matched}).Show(offset, limit)
 }
 
 // Show displays the goroutines with the offset and limit.
-func (gd GoroutineDump) Show(offset, limit int) {
-	for i := offset; i < offset+limit && i < len(gd.goroutines); i++ {
-		gd.goroutines[offset+i].PrintWithColor()
+func (gd *GoroutineDump) Show(offset, limit int) {
+	goroutines := gd.Goroutines()
+	for i := offset; i < offset+limit && i < len(goroutines); i++ {
+		printGoroutine(goroutines[i], goroutines[i].Duplicates())
 	}
 }
 
-// Sort sorts the goroutine entries.
-func (gd *GoroutineDump) Sort() {
-	fmt.Printf("# of goroutines: %d\n", len(gd.goroutines))
-}
-
 // Summary prints the summary of the goroutine dump.
-func (gd GoroutineDump) Summary() {
-	fmt.Printf("# of goroutines: %d\n", len(gd.goroutines))
-	stats := map[string]int{}
-	if len(gd.goroutines) > 0 {
-		for _, g := range gd.goroutines {
-			stats[g.metas[MetaState]]++
-		}
+func (gd *GoroutineDump) Summary() {
+	fmt.Printf("# of goroutines: %d\n", len(gd.Goroutines()))
+	stats, flagStats := gd.Stats()
+	if len(gd.Goroutines()) > 0 {
 		fmt.Println()
 	}
 	if len(stats) > 0 {
-		states := make([]string, 0, 10)
-		for k := range stats {
-			states = append(states, k)
-		}
-		sort.Sort(sort.StringSlice(states))
-
-		for _, k := range states {
-			fmt.Printf("%15s: %d\n", k, stats[k])
-		}
-		fmt.Println()
+		printStats(stats)
+	}
+	if len(flagStats) > 0 {
+		fmt.Println("Flags:")
+		printStats(flagStats)
 	}
 }
 
-// NewGoroutineDump creates and returns a new GoroutineDump.
-func NewGoroutineDump() *GoroutineDump {
-	return &GoroutineDump{
-		goroutines: []*Goroutine{},
+// LabelSummary prints the number of goroutines for each value of the pprof
+// label key.
+func (gd *GoroutineDump) LabelSummary(key string) {
+	stats := map[string]int{}
+	for _, g := range gd.Goroutines() {
+		if v, ok := g.Labels()[key]; ok {
+			stats[v]++
+		} else {
+			stats["(none)"]++
+		}
 	}
+	fmt.Printf("Label %q:\n", key)
+	printStats(stats)
 }
 
-// NewGoroutineDumpFromMap creates and returns a new GoroutineDump from a map.
-func NewGoroutineDumpFromMap(gs map[int]*Goroutine) *GoroutineDump {
-	gd := &GoroutineDump{
-		goroutines: []*Goroutine{},
+// printStats prints the counts in stats sorted by key.
+func printStats(stats map[string]int) {
+	keys := make([]string, 0, len(stats))
+	for k := range stats {
+		keys = append(keys, k)
 	}
-	for _, v := range gs {
-		gd.goroutines = append(gd.goroutines, v)
+	sort.Sort(sort.StringSlice(keys))
+
+	for _, k := range keys {
+		fmt.Printf("%15s: %d\n", k, stats[k])
 	}
-	return gd
+	fmt.Println()
 }
 
-func (gd *GoroutineDump) withCondition(cond string, callback func(int, *Goroutine, bool) *Goroutine) ([]*Goroutine, error) {
-	cond = strings.Trim(cond, "\"")
-	expression, err := govaluate.NewEvaluableExpressionWithFunctions(cond, functions)
+// compile compiles a condition, which may reference the named conditions.
+func compile(cond string) (*dump.Condition, error) {
+	return dump.CompileCondition(cond, macros)
+}
+
+// withCondition returns the goroutines matching the condition if keep is true,
+// or the ones not matching it otherwise, and prints how many are deleted and
+// kept.
+func (gd *GoroutineDump) withCondition(cond string, keep bool) (*dump.GoroutineDump, error) {
+	c, err := compile(cond)
+	if err != nil {
+		return nil, err
+	}
+	kept := gd.GoroutineDump.Copy()
+	if keep {
+		err = kept.Keep(c)
+	} else {
+		err = kept.Delete(c)
+	}
 	if err != nil {
 		return nil, err
 	}
+	fmt.Printf("Deleted %d goroutines, kept %d.\n", len(gd.Goroutines())-len(kept.Goroutines()), len(kept.Goroutines()))
+	return kept, nil
+}
 
-	goroutines := make([]*Goroutine, 0, len(gd.goroutines))
-	for i, g := range gd.goroutines {
-		params := map[string]interface{}{
-			"id":       g.id,
-			"dups":     len(g.duplicates),
-			"duration": g.duration,
-			"lines":    g.lines,
-			"state":    g.metas[MetaState],
-			"trace":    g.trace,
-		}
-		res, err := expression.Evaluate(params)
-		if err != nil {
-			return nil, err
-		}
-		if val, ok := res.(bool); ok {
-			if gor := callback(i, g, val); gor != nil {
-				goroutines = append(goroutines, gor)
-			}
-		} else {
-			return nil, errors.New("argument expression should return a boolean")
-		}
+// filter returns the goroutines matching the condition, without printing.
+func (gd *GoroutineDump) filter(cond string) (*dump.GoroutineDump, error) {
+	c, err := compile(cond)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Printf("Deleted %d goroutines, kept %d.\n", len(gd.goroutines)-len(goroutines), len(goroutines))
-	return goroutines, nil
+	return gd.Filter(c)
 }
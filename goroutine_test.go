@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const compactDump = `goroutine 5 [chan receive, 12 minutes]:
+runtime.gopark(0x0?, 0x0?, 0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/proc.go:424 +0xce
+runtime.chanrecv(0xc000020060, 0x0, 0x1)
+	/usr/local/go/src/runtime/chan.go:639 +0x3bc
+runtime.chanrecv1(0xc000020060?, 0x0?)
+	/usr/local/go/src/runtime/chan.go:489 +0x12
+google.golang.org/grpc/internal/transport.(*recvBufferReader).read(...)
+	/go/pkg/mod/google.golang.org/grpc/internal/transport/transport.go:180
+github.com/our/svc/pool.(*Pool).worker(0xc000010000)
+	/src/pool/pool.go:20 +0x30
+github.com/our/svc/server.(*Server).Run(0xc000012000)
+	/src/server/server.go:42 +0x55
+created by main.main in goroutine 1
+	/src/main.go:12 +0x40
+`
+
+func TestPrintCompact(t *testing.T) {
+	plainSettings(t)
+	settings.Compact = true
+	g := mustLoad(t, compactDump).Goroutines()[0]
+
+	out := captureStdout(t, func() { printGoroutine(g, nil) })
+	want := `goroutine 5 [chan receive, 12 minutes]:
+… 3 hidden frames
+google.golang.org/grpc/internal/transport.(*recvBufferReader).read(...)
+	/go/pkg/mod/google.golang.org/grpc/internal/transport/transport.go:180
+github.com/our/svc/pool.(*Pool).worker(0xc000010000)
+	/src/pool/pool.go:20 +0x30
+github.com/our/svc/server.(*Server).Run(0xc000012000)
+	/src/server/server.go:42 +0x55
+created by main.main in goroutine 1
+	/src/main.go:12 +0x40
+
+`
+	if out != want {
+		t.Errorf("compact trace:\n%s\nwant:\n%s", out, want)
+	}
+
+	settings.HideFrames = []string{"google.golang.org/grpc/"}
+	out = captureStdout(t, func() { printGoroutine(g, nil) })
+	if !strings.Contains(out, "… 4 hidden frames\ngithub.com/our/svc/pool.") {
+		t.Errorf("compact trace with hide_frames:\n%s", out)
+	}
+
+	settings.Compact = false
+	out = captureStdout(t, func() { printGoroutine(g, nil) })
+	if !strings.Contains(out, "runtime.gopark") {
+		t.Errorf("full trace:\n%s", out)
+	}
+}
+
+func TestPrintCompactHighlight(t *testing.T) {
+	plainSettings(t)
+	settings.Compact = true
+	settings.Color = true
+	g := mustLoad(t, compactDump).Goroutines()[0]
+
+	for _, tc := range []struct {
+		ownModules []string
+		want       string
+	}{
+		// Without own_modules, any frame outside of the standard library.
+		{nil, "google.golang.org/grpc/internal/transport."},
+		{[]string{"github.com/our/svc/server"}, "github.com/our/svc/server."},
+	} {
+		settings.OwnModules = tc.ownModules
+		out := captureStdout(t, func() { printGoroutine(g, nil) })
+		if !strings.Contains(out, "\x1b[33m"+tc.want) || strings.Count(out, "\x1b[33m") != 1 {
+			t.Errorf("own_modules %q, highlighted:\n%q\nwant %s", tc.ownModules, out, tc.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Package text has the text helpers shared by the dump package and the
+// interactive shell.
+package text
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// ReadLines calls fn with each line read from r and its line number. Unlike
+// bufio.Scanner, it doesn't limit the length of a line, as frames with long
+// generic type names or argument lists can be huge.
+func ReadLines(r io.Reader, fn func(int, string)) error {
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			line = strings.TrimSuffix(line, "\n")
+			fn(n, strings.TrimSuffix(line, "\r"))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// RewritePrefix replaces the longest prefix of path found in the prefixes
+// map, and tells if any is found. A prefix matches whole directories only,
+// e.g. "/go/src/foo" matches "/go/src/foo/bar.go" but not "/go/src/foobar".
+func RewritePrefix(path string, prefixes map[string]string) (string, bool) {
+	longest := ""
+	for prefix := range prefixes {
+		if len(prefix) > len(longest) && hasDirPrefix(path, prefix) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return path, false
+	}
+	return prefixes[longest] + strings.TrimPrefix(path, longest), true
+}
+
+// hasDirPrefix tells if path is in the directory prefix, or is the prefix.
+func hasDirPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
@@ -0,0 +1,29 @@
+package text
+
+import (
+	"testing"
+)
+
+func TestRewritePrefix(t *testing.T) {
+	prefixes := map[string]string{
+		"/go/src/foo":         "/home/me/foo",
+		"/go/src/foo/vendor/": "/home/me/vendor/",
+		"/usr/local/go/":      "/opt/go/",
+	}
+	for _, tc := range []struct {
+		path, want string
+		ok         bool
+	}{
+		{"/go/src/foo/bar.go:10 +0x20", "/home/me/foo/bar.go:10 +0x20", true},
+		{"/go/src/foo", "/home/me/foo", true},
+		{"/go/src/foo/vendor/x/y.go:3", "/home/me/vendor/x/y.go:3", true},
+		{"/go/src/foobar/bar.go:10", "/go/src/foobar/bar.go:10", false},
+		{"/usr/local/go/src/runtime/proc.go:398", "/opt/go/src/runtime/proc.go:398", true},
+		{"/usr/local/gopher/main.go:1", "/usr/local/gopher/main.go:1", false},
+	} {
+		got, ok := RewritePrefix(tc.path, prefixes)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("RewritePrefix(%q) = %q, %v, want %q, %v", tc.path, got, ok, tc.want, tc.ok)
+		}
+	}
+}
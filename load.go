@@ -1,16 +1,28 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"strings"
-)
 
-var (
-	startLinePattern = regexp.MustCompile(`^goroutine\s+(\d+)\s+\[(.*)\]:$`)
+	"github.com/linuxerwang/goroutine-inspect/dump"
 )
 
+// newParser returns a parser rewriting paths by the path_rewrites setting,
+// and warning about malformed goroutine sections.
+func newParser(dec dump.LineDecoder) *dump.Parser {
+	return &dump.Parser{
+		Decoder:  dec,
+		Rewrites: settings.PathRewrites,
+		Warn: func(n int, err error) {
+			colorPrintf("[fg-yellow]Warning: line %d: %s, section skipped.[reset]\n", n, err)
+		},
+	}
+}
+
 func load(fn string) (*GoroutineDump, error) {
 	fn = strings.Trim(fn, "\"")
 	f, err := os.Open(fn)
@@ -18,36 +30,94 @@ func load(fn string) (*GoroutineDump, error) {
 		return nil, err
 	}
 	defer f.Close()
+	return loadFrom(f)
+}
+
+// loadSymbolized loads a dump, and resolves the bare PCs in the stack traces
+// by the line table of the binary producing the dump.
+func loadSymbolized(fn, binary string) (*GoroutineDump, error) {
+	s, err := dump.NewSymbolizer(binary)
+	if err != nil {
+		return nil, err
+	}
+	gd, err := load(fn)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Symbolized %d goroutines.\n", gd.Symbolize(s))
+	if gd.BuildID() != "" && s.BuildID() != "" && gd.BuildID() != s.BuildID() {
+		colorPrintf("[fg-yellow]Warning: the build ID of %s is %s, but the dump is from build ID %s.[reset]\n", binary, s.BuildID(), gd.BuildID())
+	}
+	if notFound, total := s.NotFound(); notFound > 0 {
+		colorPrintf("[fg-yellow]Warning: %d of %d PCs not found in %s, is it the binary producing the dump?[reset]\n", notFound, total, binary)
+	}
+	return gd, nil
+}
+
+// loadFrom reads a goroutine dump, or a goroutine profile written with
+// debug=1 or in the protobuf format.
+func loadFrom(r io.Reader) (*GoroutineDump, error) {
+	gd, err := newParser(nil).Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return &GoroutineDump{gd}, nil
+}
 
-	dump := NewGoroutineDump()
-	var goroutine *Goroutine
-
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if startLinePattern.MatchString(line) {
-			goroutine, err = NewGoroutine(line)
-			if err != nil {
-				return nil, err
-			}
-			dump.Add(goroutine)
-		} else if line == "" {
-			// End of a goroutine section.
-			if goroutine != nil {
-				goroutine.Freeze()
-			}
-			goroutine = nil
-		} else if goroutine != nil {
-			goroutine.AddLine(line)
+// extract loads the goroutine dump embedded in a log file. Each line of the
+// log file is expected to carry a prefix, which is stripped before parsing.
+// If prefix is empty, the log format is detected automatically.
+func extract(fn, prefix string) (*GoroutineDump, error) {
+	fn = strings.Trim(fn, "\"")
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	var dec dump.LineDecoder
+	if prefix != "" {
+		if !strings.HasPrefix(prefix, "^") {
+			prefix = "^" + prefix
 		}
+		re, err := regexp.Compile(prefix)
+		if err != nil {
+			return nil, err
+		}
+		dec = dump.NewRegexpDecoder(re)
+	} else {
+		name, d := dump.DetectDecoder(data)
+		if name == "" {
+			return nil, fmt.Errorf("no goroutine dump found in %s", fn)
+		}
+		fmt.Printf("Detected log format: %s.\n", name)
+		dec = d
 	}
 
-	if goroutine != nil {
-		goroutine.Freeze()
+	gd, err := newParser(dec).Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
 	}
+	return &GoroutineDump{gd}, nil
+}
 
-	if err := scanner.Err(); err != nil {
+// loadAll loads a file containing several goroutine dumps, e.g. produced by
+// sending SIGQUIT repeatedly or by concatenating periodic dumps, and returns
+// them as separate snapshots.
+func loadAll(fn string) ([]*GoroutineDump, error) {
+	fn = strings.Trim(fn, "\"")
+	f, err := os.Open(fn)
+	if err != nil {
 		return nil, err
 	}
-	return dump, nil
+	defer f.Close()
+
+	dumps, err := newParser(nil).ParseAll(f)
+	if err != nil {
+		return nil, err
+	}
+	gds := make([]*GoroutineDump, 0, len(dumps))
+	for _, d := range dumps {
+		gds = append(gds, &GoroutineDump{d})
+	}
+	return gds, nil
 }
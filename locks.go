@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/linuxerwang/goroutine-inspect/dump"
+)
+
+var (
+	// lockFuncs take the mutex as the first argument, when a goroutine blocks
+	// on a lock. RWMutex embeds its writer Mutex as the first field, so the
+	// addresses are the same.
+	lockFuncs = map[string]bool{
+		"sync.(*Mutex).Lock":              true,
+		"sync.(*Mutex).lockSlow":          true,
+		"sync.(*RWMutex).Lock":            true,
+		"sync.(*RWMutex).RLock":           true,
+		"internal/sync.(*Mutex).Lock":     true,
+		"internal/sync.(*Mutex).lockSlow": true,
+	}
+
+	// An argument word which is an address, e.g. "0xc0000140a0". Words
+	// followed by "?" may be inaccurate, and are ignored.
+	addrPattern = regexp.MustCompile(`^0x[0-9a-f]+$`)
+)
+
+// lockWait is a goroutine blocked on a mutex.
+type lockWait struct {
+	g      *dump.Goroutine
+	addr   string // The address of the mutex, empty if unknown.
+	caller string // The function calling Lock.
+}
+
+// waitingLock tells the mutex a goroutine is blocked on, if any. Only the
+// runtime and sync frames are allowed above the Lock call. The address is
+// unknown if it's inaccurate or elided, e.g. for the inlined RWMutex.RLock.
+func waitingLock(g *dump.Goroutine) (*lockWait, bool) {
+	var w *lockWait
+	for _, f := range g.Frames() {
+		if lockFuncs[f.Func()] {
+			if w == nil {
+				w = &lockWait{g: g}
+			}
+			if addr, ok := firstArgAddr(f); w.addr == "" && ok {
+				w.addr = addr
+			}
+			continue
+		}
+		if w != nil {
+			w.caller = f.Func()
+			break
+		}
+		if f.Package() != "runtime" && f.Package() != "sync" && f.Package() != "internal/sync" {
+			return nil, false
+		}
+	}
+	if w == nil {
+		return nil, false
+	}
+	return w, true
+}
+
+// firstArgAddr returns the first argument word of a frame if it's an
+// address.
+func firstArgAddr(f *dump.Frame) (string, bool) {
+	word := strings.TrimSpace(strings.SplitN(f.Args(), ",", 2)[0])
+	return word, addrPattern.MatchString(word)
+}
+
+// argAddrs returns the argument words of a frame which are addresses.
+func argAddrs(f *dump.Frame) []string {
+	var addrs []string
+	for _, word := range strings.FieldsFunc(f.Args(), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '{' || r == '}'
+	}) {
+		if addrPattern.MatchString(word) {
+			addrs = append(addrs, word)
+		}
+	}
+	return addrs
+}
+
+// lockHolder is a goroutine which probably holds a mutex.
+type lockHolder struct {
+	g      *dump.Goroutine
+	reason string
+	found  int // How the holder is found, one of holderByAddr etc.
+}
+
+// The ways holders are found, the most reliable first.
+const (
+	// One of its frames has the mutex address as an argument.
+	holderByAddr = iota
+	// It's in a function which the waiters call Lock from.
+	holderByCaller
+	// It waits for another mutex, maybe while holding this one, e.g. the
+	// classic deadlock of two goroutines locking global mutexes a and b in
+	// the opposite order, whose addresses aren't in any arguments.
+	holderByWait
+)
+
+// lockHolders returns the goroutines which probably hold the mutex at addr,
+// the most probable first, see holderByAddr etc.
+func lockHolders(gd *GoroutineDump, addr string, waits map[*dump.Goroutine]*lockWait, waiters []*lockWait) []*lockHolder {
+	callers := map[string]bool{}
+	for _, w := range waiters {
+		if w.caller != "" {
+			callers[w.caller] = true
+		}
+	}
+
+	var holders []*lockHolder
+	for _, g := range gd.Goroutines() {
+		w, waiting := waits[g]
+		if waiting && w.addr == addr {
+			continue
+		}
+		var h *lockHolder
+	frames:
+		for _, f := range g.Frames() {
+			if lockFuncs[f.Func()] {
+				continue
+			}
+			for _, a := range argAddrs(f) {
+				if a == addr {
+					h = &lockHolder{g: g, found: holderByAddr, reason: fmt.Sprintf("%s passed %s as an argument", f.Func(), addr)}
+					break frames
+				}
+			}
+			if h == nil && callers[f.Func()] {
+				h = &lockHolder{g: g, found: holderByCaller, reason: fmt.Sprintf("in %s, which the waiters lock from", f.Func())}
+			}
+		}
+		if h == nil && waiting {
+			h = &lockHolder{g: g, found: holderByWait, reason: fmt.Sprintf("waiting for another mutex in %s, maybe while holding this one", w.caller)}
+		}
+		if h != nil {
+			holders = append(holders, h)
+		}
+	}
+	sort.SliceStable(holders, func(i, j int) bool {
+		return holders[i].found < holders[j].found
+	})
+	return holders
+}
+
+// Locks prints the goroutines blocked on mutexes grouped by the mutex
+// address, with the probable holder of each mutex, and reports cycles of
+// goroutines waiting for each other as potential deadlocks.
+func (gd *GoroutineDump) Locks() {
+	waits := map[*dump.Goroutine]*lockWait{}
+	byAddr := map[string][]*lockWait{}
+	var addrs []string
+	for _, g := range gd.Goroutines() {
+		w, ok := waitingLock(g)
+		if !ok {
+			continue
+		}
+		waits[g] = w
+		if _, ok := byAddr[w.addr]; !ok {
+			addrs = append(addrs, w.addr)
+		}
+		byAddr[w.addr] = append(byAddr[w.addr], w)
+	}
+	if len(addrs) == 0 {
+		fmt.Println("No goroutines blocked on mutexes.")
+		return
+	}
+	// The waiters of unknown mutexes go last.
+	sort.SliceStable(addrs, func(i, j int) bool {
+		if (addrs[i] == "") != (addrs[j] == "") {
+			return addrs[j] == ""
+		}
+		return len(byAddr[addrs[i]]) > len(byAddr[addrs[j]])
+	})
+
+	// The probable holder of the mutex each goroutine waits for.
+	waitsFor := map[*dump.Goroutine]*lockHolder{}
+	for _, addr := range addrs {
+		waiters := byAddr[addr]
+		if addr == "" {
+			colorPrintf("[fg-green]Unknown lock[reset]: %d goroutine(s) waiting, the address isn't in the stack traces\n", len(waiters))
+		} else {
+			colorPrintf("[fg-green]Mutex %s[reset]: %d goroutine(s) waiting\n", addr, len(waiters))
+		}
+		callers := map[string][]int{}
+		var order []string
+		for _, w := range waiters {
+			if _, ok := callers[w.caller]; !ok {
+				order = append(order, w.caller)
+			}
+			callers[w.caller] = append(callers[w.caller], w.g.ID())
+		}
+		for _, caller := range order {
+			fmt.Printf("  waiting in %s: %s\n", caller, formatIds(callers[caller]))
+		}
+
+		if addr == "" {
+			fmt.Println()
+			continue
+		}
+		holders := lockHolders(gd, addr, waits, waiters)
+		if len(holders) == 0 {
+			fmt.Println("  holder: unknown")
+		} else {
+			h := holders[0]
+			colorPrintf("  [fg-yellow]probable holder[reset]: goroutine %d [%s], %s\n", h.g.ID(), h.g.State(), h.reason)
+			if len(holders) > 1 {
+				ids := make([]int, 0, len(holders)-1)
+				for _, h := range holders[1:] {
+					ids = append(ids, h.g.ID())
+				}
+				fmt.Printf("  other candidates: %s\n", formatIds(ids))
+			}
+			for _, w := range waiters {
+				waitsFor[w.g] = h
+			}
+		}
+		fmt.Println()
+	}
+
+	cycles := waitCycles(gd, waitsFor)
+	if len(cycles) == 0 {
+		fmt.Println("No wait-for cycles found.")
+		return
+	}
+	colorPrintf("[fg-red]Potential deadlocks[reset]: %d\n", len(cycles))
+	for _, cycle := range cycles {
+		// A holder not found by the mutex address may be a false edge.
+		guessed := false
+		for _, g := range cycle {
+			guessed = guessed || waitsFor[g].found != holderByAddr
+		}
+		if guessed {
+			colorPrintf("  [fg-yellow]guessed[reset], as some holders aren't found by the mutex address:\n")
+		}
+		for _, g := range cycle {
+			h := waitsFor[g]
+			if h.found == holderByAddr {
+				fmt.Printf("  goroutine %d waits for %s held by goroutine %d\n", g.ID(), waits[g].addr, h.g.ID())
+			} else {
+				fmt.Printf("  goroutine %d waits for %s maybe held by goroutine %d\n", g.ID(), waits[g].addr, h.g.ID())
+			}
+		}
+		fmt.Println()
+	}
+}
+
+// waitCycles finds the cycles in the wait-for graph, in which each goroutine
+// waits for at most one other goroutine.
+func waitCycles(gd *GoroutineDump, waitsFor map[*dump.Goroutine]*lockHolder) [][]*dump.Goroutine {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := map[*dump.Goroutine]int{}
+	var cycles [][]*dump.Goroutine
+	next := func(g *dump.Goroutine) *dump.Goroutine {
+		if h, ok := waitsFor[g]; ok {
+			return h.g
+		}
+		return nil
+	}
+	for _, g := range gd.Goroutines() {
+		var path []*dump.Goroutine
+		for cur := g; cur != nil && marks[cur] == unvisited; cur = next(cur) {
+			marks[cur] = visiting
+			path = append(path, cur)
+			if holder := next(cur); holder != nil && marks[holder] == visiting {
+				for i, p := range path {
+					if p == holder {
+						cycles = append(cycles, path[i:])
+						break
+					}
+				}
+			}
+		}
+		for _, p := range path {
+			marks[p] = visited
+		}
+	}
+	return cycles
+}
+
+// formatIds formats goroutine ids, e.g. "7, 9, 15".
+func formatIds(ids []int) string {
+	strs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		strs = append(strs, strconv.Itoa(id))
+	}
+	return strings.Join(strs, ", ")
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// abbaDump is from goroutines main.ab and main.ba locking global mutexes a and
+// b in the opposite order, by go1.27.
+const abbaDump = `goroutine 1 [running]:
+main.main()
+	/tmp/abba/main.go:29 +0x5d
+
+goroutine 7 [sync.Mutex.Lock]:
+internal/sync.runtime_SemacquireMutex(0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/sema.go:95 +0x25
+internal/sync.(*Mutex).lockSlow(0x562440)
+	/usr/local/go/src/internal/sync/mutex.go:149 +0x15a
+internal/sync.(*Mutex).Lock(...)
+	/usr/local/go/src/internal/sync/mutex.go:70
+sync.(*Mutex).Lock(...)
+	/usr/local/go/src/sync/mutex.go:46
+main.ab()
+	/tmp/abba/main.go:15 +0x66
+created by main.main in goroutine 1
+	/tmp/abba/main.go:25 +0x1e
+
+goroutine 8 [sync.Mutex.Lock]:
+internal/sync.runtime_SemacquireMutex(0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/sema.go:95 +0x25
+internal/sync.(*Mutex).lockSlow(0x562438)
+	/usr/local/go/src/internal/sync/mutex.go:149 +0x15a
+internal/sync.(*Mutex).Lock(...)
+	/usr/local/go/src/internal/sync/mutex.go:70
+sync.(*Mutex).Lock(...)
+	/usr/local/go/src/sync/mutex.go:46
+main.ba()
+	/tmp/abba/main.go:21 +0x66
+created by main.main in goroutine 1
+	/tmp/abba/main.go:26 +0x2a
+`
+
+// transferDump is from two goroutines calling transfer(from, to *Account),
+// which locks from.mu and then to.mu, in the opposite order, by go1.27.
+const transferDump = `goroutine 1 [running]:
+runtime/pprof.writeGoroutineStacks({0x5e1998, 0x1d4484bce030})
+	/usr/local/go/src/runtime/pprof/pprof.go:816 +0x69
+runtime/pprof.writeGoroutine({0x5e1998?, 0x1d4484bce030?}, 0x408975?)
+	/usr/local/go/src/runtime/pprof/pprof.go:779 +0x25
+runtime/pprof.(*Profile).WriteTo(0x4df856?, {0x5e1998?, 0x1d4484bce030?}, 0x1d4484c18ea8?)
+	/usr/local/go/src/runtime/pprof/pprof.go:405 +0x149
+main.main()
+	/tmp/xfer/main.go:31 +0x13d
+
+goroutine 7 [sync.Mutex.Lock]:
+internal/sync.runtime_SemacquireMutex(0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/sema.go:95 +0x25
+internal/sync.(*Mutex).lockSlow(0x1d4484bde130)
+	/usr/local/go/src/internal/sync/mutex.go:149 +0x15a
+internal/sync.(*Mutex).Lock(...)
+	/usr/local/go/src/internal/sync/mutex.go:70
+sync.(*Mutex).Lock(...)
+	/usr/local/go/src/sync/mutex.go:46
+main.transfer(0x1d4484bde120, 0x1d4484bde130, 0x1)
+	/tmp/xfer/main.go:20 +0x9c
+created by main.main in goroutine 1
+	/tmp/xfer/main.go:28 +0xa7
+
+goroutine 8 [sync.Mutex.Lock]:
+internal/sync.runtime_SemacquireMutex(0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/sema.go:95 +0x25
+internal/sync.(*Mutex).lockSlow(0x1d4484bde120)
+	/usr/local/go/src/internal/sync/mutex.go:149 +0x15a
+internal/sync.(*Mutex).Lock(...)
+	/usr/local/go/src/internal/sync/mutex.go:70
+sync.(*Mutex).Lock(...)
+	/usr/local/go/src/sync/mutex.go:46
+main.transfer(0x1d4484bde130, 0x1d4484bde120, 0x2)
+	/tmp/xfer/main.go:20 +0x9c
+created by main.main in goroutine 1
+	/tmp/xfer/main.go:29 +0x105
+`
+
+// lockBothDump is from a.lockBoth(&b) and b.lockBoth(&a), with the receivers
+// printed as inaccurate, and a reader blocked in the inlined RWMutex.RLock, by
+// go1.27.
+const lockBothDump = `goroutine 6 [sync.Mutex.Lock]:
+internal/sync.runtime_SemacquireMutex(0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/sema.go:95 +0x25
+internal/sync.(*Mutex).lockSlow(0x60e7f0)
+	/usr/local/go/src/internal/sync/mutex.go:149 +0x15a
+internal/sync.(*Mutex).Lock(...)
+	/usr/local/go/src/internal/sync/mutex.go:70
+sync.(*Mutex).Lock(...)
+	/usr/local/go/src/sync/mutex.go:46
+main.(*S).lockBoth(0x0?, 0x60e7f0)
+	/tmp/dl/main.go:22 +0x5b
+created by main.main in goroutine 1
+	/tmp/dl/main.go:32 +0x4d
+
+goroutine 7 [sync.Mutex.Lock]:
+internal/sync.runtime_SemacquireMutex(0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/sema.go:95 +0x25
+internal/sync.(*Mutex).lockSlow(0x60e7e0)
+	/usr/local/go/src/internal/sync/mutex.go:149 +0x15a
+internal/sync.(*Mutex).Lock(...)
+	/usr/local/go/src/internal/sync/mutex.go:70
+sync.(*Mutex).Lock(...)
+	/usr/local/go/src/sync/mutex.go:46
+main.(*S).lockBoth(0x0?, 0x60e7e0)
+	/tmp/dl/main.go:22 +0x5b
+created by main.main in goroutine 1
+	/tmp/dl/main.go:33 +0x8a
+
+goroutine 8 [sync.RWMutex.RLock]:
+sync.runtime_SemacquireRWMutexR(0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/sema.go:100 +0x25
+sync.(*RWMutex).RLock(...)
+	/usr/local/go/src/sync/rwmutex.go:74
+main.reader()
+	/tmp/dl/main.go:27 +0x35
+created by main.main in goroutine 1
+	/tmp/dl/main.go:35 +0xa5
+
+goroutine 13 [select (no cases)]:
+main.main.func3()
+	/tmp/dl/main.go:42 +0xf
+created by main.main in goroutine 1
+	/tmp/dl/main.go:42 +0x185
+`
+
+func TestLocks(t *testing.T) {
+	plainSettings(t)
+	for _, tc := range []struct {
+		name string
+		dump string
+		want string
+	}{
+		{"abba", abbaDump, `Mutex 0x562440: 1 goroutine(s) waiting
+  waiting in main.ab: 7
+  probable holder: goroutine 8 [sync.Mutex.Lock], waiting for another mutex in main.ba, maybe while holding this one
+
+Mutex 0x562438: 1 goroutine(s) waiting
+  waiting in main.ba: 8
+  probable holder: goroutine 7 [sync.Mutex.Lock], waiting for another mutex in main.ab, maybe while holding this one
+
+Potential deadlocks: 1
+  guessed, as some holders aren't found by the mutex address:
+  goroutine 7 waits for 0x562440 maybe held by goroutine 8
+  goroutine 8 waits for 0x562438 maybe held by goroutine 7
+
+`},
+		{"transfer", transferDump, `Mutex 0x1d4484bde130: 1 goroutine(s) waiting
+  waiting in main.transfer: 7
+  probable holder: goroutine 8 [sync.Mutex.Lock], main.transfer passed 0x1d4484bde130 as an argument
+
+Mutex 0x1d4484bde120: 1 goroutine(s) waiting
+  waiting in main.transfer: 8
+  probable holder: goroutine 7 [sync.Mutex.Lock], main.transfer passed 0x1d4484bde120 as an argument
+
+Potential deadlocks: 1
+  goroutine 7 waits for 0x1d4484bde130 held by goroutine 8
+  goroutine 8 waits for 0x1d4484bde120 held by goroutine 7
+
+`},
+		{"lock both", lockBothDump, `Mutex 0x60e7f0: 1 goroutine(s) waiting
+  waiting in main.(*S).lockBoth: 6
+  probable holder: goroutine 7 [sync.Mutex.Lock], in main.(*S).lockBoth, which the waiters lock from
+  other candidates: 8
+
+Mutex 0x60e7e0: 1 goroutine(s) waiting
+  waiting in main.(*S).lockBoth: 7
+  probable holder: goroutine 6 [sync.Mutex.Lock], in main.(*S).lockBoth, which the waiters lock from
+  other candidates: 8
+
+Unknown lock: 1 goroutine(s) waiting, the address isn't in the stack traces
+  waiting in main.reader: 8
+
+Potential deadlocks: 1
+  guessed, as some holders aren't found by the mutex address:
+  goroutine 6 waits for 0x60e7f0 maybe held by goroutine 7
+  goroutine 7 waits for 0x60e7e0 maybe held by goroutine 6
+
+`},
+	} {
+		gd := mustLoad(t, tc.dump)
+		if out := captureStdout(t, gd.Locks); out != tc.want {
+			t.Errorf("%s: locks() printed:\n%s\nwant:\n%s", tc.name, out, tc.want)
+		}
+	}
+}
+
+func TestLocksQueue(t *testing.T) {
+	plainSettings(t)
+	// Goroutine 8 holds the mutex the others wait for, in another function.
+	gd := mustLoad(t, strings.Replace(transferDump, "goroutine 8 [sync.Mutex.Lock]:", `goroutine 9 [sync.Mutex.Lock]:
+internal/sync.runtime_SemacquireMutex(0x0?, 0x0?, 0x0?)
+	/usr/local/go/src/runtime/sema.go:95 +0x25
+internal/sync.(*Mutex).lockSlow(0x1d4484bde130)
+	/usr/local/go/src/internal/sync/mutex.go:149 +0x15a
+internal/sync.(*Mutex).Lock(...)
+	/usr/local/go/src/internal/sync/mutex.go:70
+sync.(*Mutex).Lock(...)
+	/usr/local/go/src/sync/mutex.go:46
+main.report()
+	/tmp/xfer/main.go:40 +0x9c
+
+goroutine 8 [sync.Mutex.Lock]:`, 1))
+
+	out := captureStdout(t, gd.Locks)
+	if !strings.HasPrefix(out, `Mutex 0x1d4484bde130: 2 goroutine(s) waiting
+  waiting in main.transfer: 7
+  waiting in main.report: 9
+  probable holder: goroutine 8 [sync.Mutex.Lock], main.transfer passed 0x1d4484bde130 as an argument
+`) {
+		t.Errorf("locks() printed:\n%s", out)
+	}
+
+	if out := captureStdout(t, mustLoad(t, sampleDump).Locks); out != "No goroutines blocked on mutexes.\n" {
+		t.Errorf("locks() without waiters printed %q", out)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/linuxerwang/goroutine-inspect/dump"
+)
+
+const maxAliasDepth = 10
+
+var (
+	defPattern   = regexp.MustCompile(`^def\s+([_a-zA-Z][_a-zA-Z0-9]*)\s*=\s*(.*)$`)
+	aliasPattern = regexp.MustCompile(`^alias\s+([_a-zA-Z][_a-zA-Z0-9]*)\s*=\s*(.*)$`)
+	undefPattern = regexp.MustCompile(`^undef\s+([_a-zA-Z][_a-zA-Z0-9]*)$`)
+
+	macros     = map[string]string{} // Named conditions.
+	aliasDepth = 0
+)
+
+// define defines a named condition, which can be used in place of a condition
+// argument, or referenced in other conditions by its name.
+func define(name, value string) error {
+	cond, err := unquote(value)
+	if err != nil {
+		return fmt.Errorf("invalid condition string %s", strings.TrimSpace(value))
+	}
+	if cond == "" {
+		return fmt.Errorf("expect \"def %s = \"<condition>\"\"", name)
+	}
+	if dump.IsProperty(name) {
+		return fmt.Errorf("%s is a goroutine property", name)
+	}
+	// Compiled with the new definition to reject references to itself.
+	defs := map[string]string{name: cond}
+	for n, c := range macros {
+		if n != name {
+			defs[n] = c
+		}
+	}
+	if _, err := dump.CompileCondition(cond, defs); err != nil {
+		return err
+	}
+	macros[name] = cond
+	return saveMacros()
+}
+
+// defineAlias defines an alias for a sequence of statements separated by ";".
+func defineAlias(name, stmts string) error {
+	if strings.TrimSpace(stmts) == "" {
+		return fmt.Errorf("expect \"alias %s = <stmt>; <stmt>...\"", name)
+	}
+	if _, ok := commands[name]; ok {
+		return fmt.Errorf("%s is a command", name)
+	}
+	if cycle := aliasCycle(name, stmts); len(cycle) == 2 {
+		return fmt.Errorf("alias %s references itself", name)
+	} else if cycle != nil {
+		return fmt.Errorf("aliases reference each other: %s", strings.Join(cycle, " -> "))
+	}
+	settings.Aliases[name] = stmts
+	return saveAliases()
+}
+
+// aliasCycle returns the aliases referencing each other if alias name is
+// defined as stmts, starting and ending with name, or nil if there's no cycle.
+func aliasCycle(name, stmts string) []string {
+	visited := map[string]bool{}
+	var visit func(path []string, stmts string) []string
+	visit = func(path []string, stmts string) []string {
+		for _, stmt := range splitStatements(stmts) {
+			if stmt == name {
+				return append(path, name)
+			}
+			if next, ok := settings.Aliases[stmt]; ok && !visited[stmt] {
+				visited[stmt] = true
+				if cycle := visit(append(path, stmt), next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		return nil
+	}
+	return visit([]string{name}, stmts)
+}
+
+// undefine removes a named condition or an alias.
+func undefine(name string) error {
+	if _, ok := macros[name]; ok {
+		delete(macros, name)
+		return saveMacros()
+	}
+	if _, ok := settings.Aliases[name]; ok {
+		delete(settings.Aliases, name)
+		return saveAliases()
+	}
+	return fmt.Errorf("%s is not defined", name)
+}
+
+// lookupAlias returns the statements of an alias.
+func lookupAlias(name string) ([]string, bool) {
+	stmts, ok := settings.Aliases[name]
+	if !ok {
+		return nil, false
+	}
+	return splitStatements(stmts), true
+}
+
+// splitStatements splits statements separated by ";", except the ones in
+// string literals.
+func splitStatements(s string) []string {
+	var stmts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				// Skip the escaped character.
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == ';':
+			if stmt := strings.TrimSpace(s[start:i]); stmt != "" {
+				stmts = append(stmts, stmt)
+			}
+			start = i + 1
+		}
+	}
+	if stmt := strings.TrimSpace(s[start:]); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+// condArg returns the condition passed as a function argument, which is
+// either a string literal or the name of a named condition.
+func condArg(e ast.Expr) (string, error) {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return "", fmt.Errorf("invalid condition %s", e.Value)
+		}
+		cond, err := strconv.Unquote(e.Value)
+		if err != nil {
+			return "", fmt.Errorf("invalid condition %s", e.Value)
+		}
+		return cond, nil
+	case *ast.Ident:
+		if cond, ok := macros[e.Name]; ok {
+			return cond, nil
+		}
+		return "", fmt.Errorf("named condition %s not defined", e.Name)
+	default:
+		return "", errors.New("expect a condition string or name")
+	}
+}
+
+// unquote returns the condition of a def statement, which is either a Go
+// string literal or taken verbatim if it isn't quoted.
+func unquote(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > 0 && (s[0] == '"' || s[0] == '`') {
+		return strconv.Unquote(s)
+	}
+	return s, nil
+}
+
+// printMacros prints the named conditions and aliases.
+func printMacros() {
+	if len(macros) == 0 && len(settings.Aliases) == 0 {
+		fmt.Println("No named conditions or aliases defined.")
+		return
+	}
+	for _, name := range sortedKeys(macros) {
+		fmt.Printf("def %s = %s\n", name, strconv.Quote(macros[name]))
+	}
+	for _, name := range sortedKeys(settings.Aliases) {
+		fmt.Printf("alias %s = %s\n", name, settings.Aliases[name])
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// loadMacros loads the named conditions from the macros file in the config
+// directory. Aliases are in the config file, see Settings.
+func loadMacros() error {
+	f, err := os.Open(getMacrosFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		l := strings.TrimSpace(scanner.Text())
+		if m := defPattern.FindStringSubmatch(l); m != nil {
+			cond, err := unquote(m[2])
+			if err != nil {
+				return fmt.Errorf("invalid macros file %s: invalid condition string %s", f.Name(), m[2])
+			}
+			macros[m[1]] = cond
+		}
+	}
+	return scanner.Err()
+}
+
+// saveMacros saves the named conditions to the macros file, in the same
+// syntax as defining them in the interactive shell.
+func saveMacros() error {
+	f, err := os.Create(getMacrosFile())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, name := range sortedKeys(macros) {
+		fmt.Fprintf(w, "def %s = %s\n", name, strconv.Quote(macros[name]))
+	}
+	return w.Flush()
+}
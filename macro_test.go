@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDefine(t *testing.T) {
+	newShell(t)
+	workspace["x"] = mustLoad(t, sampleDump)
+
+	out := run(t,
+		`def waiting = "state == 'chan receive'"`,
+		`def stuck = waiting && duration > 10`,
+		`def worker = "contains(trace, \"worker\")"`,
+		`y = x.copy(stuck)`,
+		`z = x.copy("contains(trace, \"main.go:21\")")`,
+		`w = x.copy(worker)`,
+	)
+	if strings.Contains(out, "Error") {
+		t.Fatalf("unexpected error:\n%s", out)
+	}
+	for name, want := range map[string]int{"y": 2, "z": 1, "w": 3} {
+		if got := len(workspace[name].Goroutines()); got != want {
+			t.Errorf("%s has %d goroutines, want %d", name, got, want)
+		}
+	}
+}
+
+func TestDefineErrors(t *testing.T) {
+	newShell(t)
+	macros["ping"] = "id > 1"
+
+	for _, tc := range []struct {
+		name, cond string
+		err        string
+	}{
+		{"baz", `"qux"`, "qux is neither a goroutine property nor a named condition"},
+		{"loop", `"loop || id == 1"`, "named condition loop references itself"},
+		{"ping", `"pong"`, "pong is neither"},
+		{"state", `"id > 1"`, "state is a goroutine property"},
+		{"bad", `"id > \q"`, "invalid condition string"},
+		{"empty", `""`, "expect"},
+	} {
+		err := define(tc.name, tc.cond)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("define(%s, %s) error = %v, want %q", tc.name, tc.cond, err, tc.err)
+		}
+	}
+	if !reflect.DeepEqual(macros, map[string]string{"ping": "id > 1"}) {
+		t.Errorf("macros = %v, want ping only", macros)
+	}
+
+	if err := define("pong", `"ping && id < 9"`); err != nil {
+		t.Fatal(err)
+	}
+	err := define("ping", `"pong"`)
+	if want := "named conditions reference each other: pong -> ping -> pong"; err == nil || err.Error() != want {
+		t.Errorf("define(ping) error = %v, want %q", err, want)
+	}
+}
+
+func TestCondArgErrors(t *testing.T) {
+	newShell(t)
+	workspace["x"] = mustLoad(t, sampleDump)
+
+	for stmt, want := range map[string]string{
+		`x.keep(stuck)`:           "named condition stuck not defined",
+		`x.keep(1)`:               "invalid condition 1",
+		`x.keep("id >")`:          "Error",
+		`x.keep("stat == 'run'")`: "stat is neither",
+	} {
+		if out := run(t, stmt); !strings.Contains(out, want) {
+			t.Errorf("%s printed %q, want %q", stmt, out, want)
+		}
+	}
+	if n := len(workspace["x"].Goroutines()); n != 4 {
+		t.Errorf("x has %d goroutines after the errors, want 4", n)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	newShell(t)
+	workspace["x"] = mustLoad(t, sampleDump)
+
+	out := run(t,
+		`alias shrink = x.keep("id > 1"); x.dedup()`,
+		`alias twice = shrink; shrink`,
+		`twice`,
+	)
+	if strings.Contains(out, "Error") {
+		t.Fatalf("unexpected error:\n%s", out)
+	}
+	if n := len(workspace["x"].Goroutines()); n != 2 {
+		t.Errorf("x has %d goroutines, want 2", n)
+	}
+}
+
+func TestAliasCycles(t *testing.T) {
+	newShell(t)
+	workspace["x"] = mustLoad(t, sampleDump)
+
+	for _, tc := range []struct {
+		name, stmts string
+		err         string
+	}{
+		{"al", "x.show(0, 1); al", "alias al references itself"},
+		{"a", "b; x", ""},
+		{"b", "x.summary(); c", ""},
+		{"c", "a", "aliases reference each other: c -> a -> b -> c"},
+		{"help", "x", "help is a command"},
+		{"d", " ", "expect"},
+	} {
+		err := defineAlias(tc.name, tc.stmts)
+		if tc.err == "" && err != nil {
+			t.Errorf("defineAlias(%s, %s) error: %s", tc.name, tc.stmts, err)
+		}
+		if tc.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tc.err)) {
+			t.Errorf("defineAlias(%s, %s) error = %v, want %q", tc.name, tc.stmts, err, tc.err)
+		}
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(sortedKeys(settings.Aliases), want) {
+		t.Errorf("aliases = %v, want %v", settings.Aliases, want)
+	}
+}
+
+func TestSaveMacros(t *testing.T) {
+	newShell(t)
+	dir := tempHome(t)
+	if err := define("worker", `"contains(trace, \"worker\") && state == 'select'"`); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "macros"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `def worker = "contains(trace, \"worker\") && state == 'select'"` + "\n"; string(data) != want {
+		t.Errorf("macros file = %q, want %q", data, want)
+	}
+
+	saved := macros
+	macros = map[string]string{}
+	if err := loadMacros(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(macros, saved) {
+		t.Errorf("loaded macros = %v, want %v", macros, saved)
+	}
+
+	if out := run(t, "undef worker", "def"); out != "No named conditions or aliases defined.\n" {
+		t.Errorf("def after undef printed %q", out)
+	}
+}
+
+func TestPrintMacros(t *testing.T) {
+	newShell(t)
+	macros["waiting"] = "state == 'chan receive'"
+	settings.Aliases["stuck"] = `x.search("duration > 30")`
+
+	want := `def waiting = "state == 'chan receive'"` + "\n" + `alias stuck = x.search("duration > 30")` + "\n"
+	for _, cmd := range []string{"def", "alias"} {
+		if out := run(t, cmd); out != want {
+			t.Errorf("%s printed %q, want %q", cmd, out, want)
+		}
+	}
+}
@@ -11,29 +11,36 @@ import (
 
 	"sort"
 
-	sgr "github.com/foize/go.sgr"
 	"github.com/peterh/liner"
 )
 
 var (
 	assignPattern = regexp.MustCompile(`^\s*[_a-zA-Z][_a-zA-Z0-9]*(\s*,\s*[_a-zA-Z][_a-zA-Z0-9]*)*\s*=\s*.*$`)
 	cdPattern     = regexp.MustCompile(`^\s*cd\s*.*$`)
+	sgrTagPattern = regexp.MustCompile(`\[(reset|bold|underline|(fg|bg)-[a-z]+)\]`)
+	setPattern    = regexp.MustCompile(`^set(\s+(\S+)(\s+(.*))?)?$`)
 
 	commands = map[string]string{
-		"?":     "Show this help",
-		"cd":    "Change current working directory",
-		"clear": "Clear the workspace",
-		"exit":  "Exit the interactive shell",
-		"help":  "Show this help",
-		"ls":    "Show files in current directory",
-		"pwd":   "Show current working directory",
-		"quit":  "Quit the interactive shell",
-		"whos":  "Show all varaibles in workspace",
+		"?":       "Show this help",
+		"alias":   "Show aliases, or define one with \"alias <name> = <stmt>; <stmt>...\"",
+		"cd":      "Change current working directory",
+		"clear":   "Clear the workspace",
+		"def":     "Show named conditions, or define one with \"def <name> = \"<condition>\"\"",
+		"exit":    "Exit the interactive shell",
+		"help":    "Show this help",
+		"ls":      "Show files in current directory",
+		"persist": "Save the session settings to the config file",
+		"pwd":     "Show current working directory",
+		"quit":    "Quit the interactive shell",
+		"set":     "Show settings, or change a setting with \"set <key> <value>\"",
+		"undef":   "Remove a named condition or alias with \"undef <name>\"",
+		"whos":    "Show all varaibles in workspace",
 	}
 	cmds []string
 	line *liner.State
 
 	workspace = map[string]*GoroutineDump{}
+	snapshots = map[string][]*GoroutineDump{}
 )
 
 func init() {
@@ -45,6 +52,20 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(checkMain(os.Args[2:]))
+	}
+
+	if err := loadSettings(); err != nil {
+		fmt.Printf("Error, %s.\n", err.Error())
+	}
+	if err := loadMacros(); err != nil {
+		fmt.Printf("Error, %s.\n", err.Error())
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		os.Exit(serveMain(os.Args[2:]))
+	}
+
 	line = createLiner()
 	defer line.Close()
 	defer saveLiner(line)
@@ -56,64 +77,8 @@ func main() {
 				continue
 			}
 			line.AppendHistory(cmd)
-
-			switch cmd {
-			case "?", "help":
-				printHelp()
-			case "clear":
-				workspace = map[string]*GoroutineDump{}
-				fmt.Println("Workspace cleared.")
-			case "exit", "quit":
+			if !execute(cmd) {
 				return
-			case "ls":
-				wd, err := os.Getwd()
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
-				printDir(wd)
-			case "pwd":
-				wd, err := os.Getwd()
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
-				fmt.Println(wd)
-			case "whos":
-				if len(workspace) == 0 {
-					fmt.Println("No variables defined.")
-					continue
-				}
-				for k := range workspace {
-					fmt.Printf("%s\t", k)
-				}
-				fmt.Println()
-			default:
-				if cdPattern.MatchString(cmd) {
-					// Change directory.
-					idx := strings.Index(cmd, "cd")
-					dir := strings.TrimSpace(cmd[idx+2:])
-					if dir == "" {
-						fmt.Println("Expect command \"cd <dir>\"")
-						continue
-					}
-					if err := os.Chdir(dir); err != nil {
-						fmt.Println(err)
-					}
-					continue
-				}
-
-				// Assignment.
-				if assignPattern.MatchString(cmd) {
-					if err := assign(cmd); err != nil {
-						fmt.Printf("Error, %s.\n", err.Error())
-					}
-					continue
-				}
-
-				if err := expr(cmd); err != nil {
-					fmt.Printf("Error, %s.\n", err.Error())
-				}
 			}
 		} else if err == liner.ErrPromptAborted || err == io.EOF {
 			fmt.Println()
@@ -124,6 +89,130 @@ func main() {
 	}
 }
 
+// execute runs a command or statement. It returns false if the interactive
+// shell should quit.
+func execute(cmd string) bool {
+	if stmts, ok := lookupAlias(cmd); ok {
+		if aliasDepth >= maxAliasDepth {
+			fmt.Printf("Error, alias %s nested too deep.\n", cmd)
+			return true
+		}
+		aliasDepth++
+		defer func() { aliasDepth-- }()
+		for _, stmt := range stmts {
+			if !execute(stmt) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch cmd {
+	case "?", "help":
+		printHelp()
+	case "clear":
+		workspace = map[string]*GoroutineDump{}
+		snapshots = map[string][]*GoroutineDump{}
+		fmt.Println("Workspace cleared.")
+	case "def", "alias":
+		printMacros()
+	case "exit", "quit":
+		return false
+	case "persist":
+		if err := persistSettings(); err != nil {
+			fmt.Printf("Error, %s.\n", err.Error())
+			return true
+		}
+		fmt.Printf("Settings are saved to file %s.\n", getConfFile())
+	case "ls":
+		wd, err := os.Getwd()
+		if err != nil {
+			fmt.Println(err)
+			return true
+		}
+		printDir(wd)
+	case "pwd":
+		wd, err := os.Getwd()
+		if err != nil {
+			fmt.Println(err)
+			return true
+		}
+		fmt.Println(wd)
+	case "whos":
+		names := varNames()
+		if len(names) == 0 {
+			fmt.Println("No variables defined.")
+			return true
+		}
+		for _, k := range names {
+			fmt.Printf("%s\t", k)
+		}
+		fmt.Println()
+	default:
+		if cdPattern.MatchString(cmd) {
+			// Change directory.
+			idx := strings.Index(cmd, "cd")
+			dir := strings.TrimSpace(cmd[idx+2:])
+			if dir == "" {
+				fmt.Println("Expect command \"cd <dir>\"")
+				return true
+			}
+			if err := os.Chdir(dir); err != nil {
+				fmt.Println(err)
+			}
+			return true
+		}
+
+		if m := defPattern.FindStringSubmatch(cmd); m != nil {
+			// Define a named condition.
+			if err := define(m[1], m[2]); err != nil {
+				fmt.Printf("Error, %s.\n", err.Error())
+			}
+			return true
+		}
+
+		if m := aliasPattern.FindStringSubmatch(cmd); m != nil {
+			// Define an alias for statements.
+			if err := defineAlias(m[1], m[2]); err != nil {
+				fmt.Printf("Error, %s.\n", err.Error())
+			}
+			return true
+		}
+
+		if m := undefPattern.FindStringSubmatch(cmd); m != nil {
+			if err := undefine(m[1]); err != nil {
+				fmt.Printf("Error, %s.\n", err.Error())
+			}
+			return true
+		}
+
+		if m := setPattern.FindStringSubmatch(cmd); m != nil {
+			// Show or change settings.
+			if m[2] == "" {
+				printSettings()
+				return true
+			}
+			if err := set(m[2], strings.TrimSpace(m[4])); err != nil {
+				fmt.Printf("Error, %s.\n", err.Error())
+			}
+			return true
+		}
+
+		// Assignment.
+		if assignPattern.MatchString(cmd) {
+			if err := assign(cmd); err != nil {
+				fmt.Printf("Error, %s.\n", err.Error())
+			}
+			return true
+		}
+
+		if err := expr(cmd); err != nil {
+			fmt.Printf("Error, %s.\n", err.Error())
+		}
+	}
+	return true
+}
+
 func printDir(wd string) {
 	f, err := os.Open(wd)
 	if err != nil {
@@ -144,13 +233,56 @@ func printDir(wd string) {
 
 	for _, fi := range fis {
 		if fi.IsDir() {
-			sgr.Printf("[fg-blue]%s[reset]\n", fi.Name())
+			colorPrintf("[fg-blue]%s[reset]\n", fi.Name())
 		} else {
 			fmt.Println(fi.Name())
 		}
 	}
 }
 
+// colorPrintf prints with color tags like "[fg-blue]" turned into SGR escape
+// sequences, or with the tags stripped if color is off. "[[" prints a literal
+// "[".
+func colorPrintf(format string, a ...interface{}) {
+	format = sgrTagPattern.ReplaceAllStringFunc(format, func(tag string) string {
+		code, ok := sgrCode(tag[1 : len(tag)-1])
+		if !ok {
+			return tag
+		}
+		if !settings.Color {
+			return ""
+		}
+		return "\x1b[" + code + "m"
+	})
+	fmt.Printf(strings.Replace(format, "[[", "[", -1), a...)
+}
+
+// sgrColors are the colors of the "fg-" and "bg-" tags, in the order of their
+// SGR codes.
+var sgrColors = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// sgrCode returns the SGR code of a color tag without its brackets.
+func sgrCode(tag string) (string, bool) {
+	switch tag {
+	case "reset":
+		return "0", true
+	case "bold":
+		return "1", true
+	case "underline":
+		return "4", true
+	}
+	base := 30
+	if strings.HasPrefix(tag, "bg-") {
+		base = 40
+	}
+	for i, c := range sgrColors {
+		if tag[3:] == c {
+			return fmt.Sprint(base + i), true
+		}
+	}
+	return "", false
+}
+
 func printHelp() {
 	fmt.Println("Commands:")
 	for _, k := range cmds {
@@ -160,20 +292,49 @@ func printHelp() {
 	fmt.Println("Statements:")
 	fmt.Println("\t<var>")
 	fmt.Println("\t<var> = load(\"<file-name>\")")
+	fmt.Println("\t<var> = load(\"<file-name>\", binary=\"<binary-file-name>\")")
+	fmt.Println("\t<var> = extract(\"<log-file-name>\")")
+	fmt.Println("\t<var> = extract(\"<log-file-name>\", \"<prefix-regex>\")")
+	fmt.Println("\t<var> = load_all(\"<file-name>\")")
 	fmt.Println("\t<var> = <another-var>")
+	fmt.Println("\t<var> = <another-var>[<index>]")
+	fmt.Println("\t<var>.annotate(\"<profile-file-name>\")")
+	fmt.Println("\t<var>.channels()")
+	fmt.Println("\t<var>.check(\"<rules-file-name>\")")
 	fmt.Println("\t<var> = <another-var>.copy()")
 	fmt.Println("\t<var> = <another-var>.copy(\"<condition>\")")
+	fmt.Println("\t<var>.dedup()")
+	fmt.Println("\t<var>.dedup(\"lines|funcs\")")
 	fmt.Println("\t<var>.delete(\"<condition>\")")
 	fmt.Println("\tleft = <var>.diff(<another-var>)")
 	fmt.Println("\tleft, common = <var>.diff(<another-var>)")
 	fmt.Println("\tleft, common, right = <var>.diff(<another-var>)")
+	fmt.Println("\t<var> = <var>.intersect(<another-var>)")
+	fmt.Println("\t<var> = <var>.intersect(<another-var>, \"id|lines|funcs\")")
 	fmt.Println("\t<var>.keep(\"<condition>\")")
+	fmt.Println("\t<var>.locks()")
+	fmt.Println("\t<var>.packages()")
+	fmt.Println("\t<var>.restore_paths()")
+	fmt.Println("\t<var>.rewrite_paths()")
 	fmt.Println("\t<var>.save(\"<output-file-name>\")")
+	fmt.Println("\t<var>.save_baseline(\"<baseline-file-name>\")")
 	fmt.Println("\t<var>.search(\"<condition>\")")
 	fmt.Println("\t<var>.search(\"<condition>\", offset)")
 	fmt.Println("\t<var>.search(\"<condition>\", offset, limit)")
 	fmt.Println("\t<var>.show()")
 	fmt.Println("\t<var>.show(offset)")
 	fmt.Println("\t<var>.show(offset, limit)")
+	fmt.Println("\t<var>.sort(\"<property> [asc|desc]\")")
+	fmt.Println("\t<var>.source(<goroutine-id>, <frame-index>)")
+	fmt.Println("\t<var>.source(<goroutine-id>, <frame-index>, context)")
+	fmt.Println("\t<var> = <var>.subtract(<another-var>)")
+	fmt.Println("\t<var> = <var>.subtract(<another-var>, \"id|lines|funcs\")")
+	fmt.Println("\t<var>.summary()")
+	fmt.Println("\t<var>.summary(\"<label-key>\")")
+	fmt.Println("\t<var>.suppress(\"<baseline-file-name>\")")
+	fmt.Println("\t<var>.suspects()")
+	fmt.Println("\t<var> = <var>.union(<another-var>)")
+	fmt.Println("\t<var> = <var>.union(<another-var>, \"id|lines|funcs\")")
+	fmt.Println("\t<var> = <another-var>.copy(\"<condition>\").dedup().sort(\"<property> desc\")")
 	fmt.Println()
 }
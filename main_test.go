@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sampleDump has goroutines 5 and 6 at the same lines, and goroutine 7 in the
+// same functions at another line.
+const sampleDump = `goroutine 1 [running]:
+main.main()
+	/src/main.go:10 +0x20
+
+goroutine 5 [chan receive, 12 minutes]:
+main.worker(0xc000010000)
+	/src/main.go:20 +0x30
+created by main.main in goroutine 1
+	/src/main.go:12 +0x40
+
+goroutine 6 [chan receive, 12 minutes]:
+main.worker(0xc000010000)
+	/src/main.go:20 +0x30
+created by main.main in goroutine 1
+	/src/main.go:12 +0x40
+
+goroutine 7 [chan receive, 3 minutes]:
+main.worker(0xc000010000)
+	/src/main.go:21 +0x38
+created by main.main in goroutine 1
+	/src/main.go:12 +0x40
+`
+
+// mustLoad parses a dump from text, as load() does.
+func mustLoad(t *testing.T, s string) *GoroutineDump {
+	t.Helper()
+	gd, err := loadFrom(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("loadFrom() error: %s", err)
+	}
+	return gd
+}
+
+// plainSettings restores the default settings without color for the test.
+func plainSettings(t *testing.T) {
+	old := settings
+	t.Cleanup(func() { settings = old })
+	settings = Settings{
+		ShowLimit:    10,
+		DedupMode:    "lines",
+		Aliases:      map[string]string{},
+		PathRewrites: map[string]string{},
+		SourcePaths:  map[string]string{},
+	}
+}
+
+// tempHome points the config directory to a temporary one for the test, and
+// returns it.
+func tempHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	return filepath.Join(home, ".goroutine-inspect")
+}
+
+// newShell starts the test with an empty workspace, no named conditions or
+// aliases, the default settings and a temporary config directory.
+func newShell(t *testing.T) {
+	t.Helper()
+	plainSettings(t)
+	tempHome(t)
+	oldWorkspace, oldSnapshots, oldMacros := workspace, snapshots, macros
+	t.Cleanup(func() { workspace, snapshots, macros = oldWorkspace, oldSnapshots, oldMacros })
+	workspace = map[string]*GoroutineDump{}
+	snapshots = map[string][]*GoroutineDump{}
+	macros = map[string]string{}
+}
+
+// run executes the statements in the shell, and returns the output.
+func run(t *testing.T, stmts ...string) string {
+	t.Helper()
+	return captureStdout(t, func() {
+		for _, stmt := range stmts {
+			execute(stmt)
+		}
+	})
+}
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	func() {
+		defer func() { os.Stdout = stdout }()
+		f()
+	}()
+	w.Close()
+	return <-out
+}
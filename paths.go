@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// formatPathMap formats path prefix mappings the way parsePathMap parses.
+func formatPathMap(paths map[string]string) string {
+	l := make([]string, 0, len(paths))
+	for _, k := range sortedKeys(paths) {
+		l = append(l, k+"="+paths[k])
+	}
+	return strings.Join(l, ",")
+}
+
+// parsePathMap parses a comma separated list of path prefix mappings, e.g.
+// "/go/src/=/home/me/src/,/builds/=/home/me/".
+func parsePathMap(key, value string) (map[string]string, error) {
+	paths := map[string]string{}
+	for _, kv := range splitList(value) {
+		idx := strings.Index(kv, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid value %s for %s, expect <from-path>=<to-path>,...", value, key)
+		}
+		paths[kv[:idx]] = kv[idx+1:]
+	}
+	return paths, nil
+}
+
+// RewritePaths rewrites the path prefixes of file lines by the path_rewrites
+// setting, e.g. after changing it. The paths are rewritten when loading too.
+func (gd *GoroutineDump) RewritePaths() {
+	changed := gd.GoroutineDump.RewritePaths(settings.PathRewrites)
+	fmt.Printf("Changed the paths of %d goroutines.\n", changed)
+}
+
+// RestorePaths restores the paths of file lines as they are in the dump.
+func (gd *GoroutineDump) RestorePaths() {
+	changed := gd.GoroutineDump.RewritePaths(nil)
+	fmt.Printf("Changed the paths of %d goroutines.\n", changed)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/linuxerwang/goroutine-inspect/dump"
+	"gopkg.in/yaml.v3"
+)
+
+// rule is a check of a dump in a YAML rules file. It fires if more than
+// Threshold goroutines match the condition.
+type rule struct {
+	Name      string `yaml:"name"`
+	Severity  string `yaml:"severity"` // One of severityNames, "medium" by default.
+	Condition string `yaml:"condition"`
+	Threshold int    `yaml:"threshold"`
+
+	cond *dump.Condition
+}
+
+// loadRules reads and validates the rules in a YAML file. The conditions are
+// compiled without the named conditions of the shell, so the rules mean the
+// same to everyone.
+func loadRules(fn string) ([]*rule, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*rule
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %s", fn, err)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rules in file %s", fn)
+	}
+
+	for i, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("invalid rules file %s: rule #%d has no name", fn, i+1)
+		}
+		if r.Severity == "" {
+			r.Severity = severityNames[severityMedium]
+		}
+		if severity(r.Severity) < 0 {
+			return nil, fmt.Errorf("invalid rules file %s: rule %q has unknown severity %s", fn, r.Name, r.Severity)
+		}
+		if r.Condition == "" {
+			return nil, fmt.Errorf("invalid rules file %s: rule %q has no condition", fn, r.Name)
+		}
+		if r.cond, err = dump.CompileCondition(r.Condition, nil); err != nil {
+			return nil, fmt.Errorf("invalid rules file %s: rule %q: %s", fn, r.Name, err)
+		}
+		if r.Threshold < 0 {
+			return nil, fmt.Errorf("invalid rules file %s: rule %q has negative threshold", fn, r.Name)
+		}
+	}
+	return rules, nil
+}
+
+// severity returns the index of a severity name in severityNames, or -1.
+func severity(name string) int {
+	for i, s := range severityNames {
+		if s == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Check runs the rules in a file against the dump, prints the result of each
+// rule and returns the number of rules fired. A goroutine deduplicated before
+// counts as all of its duplicates.
+func (gd *GoroutineDump) Check(fn string) (int, error) {
+	rules, err := loadRules(fn)
+	if err != nil {
+		return 0, err
+	}
+
+	fired := 0
+	for _, r := range rules {
+		matched, err := gd.Filter(r.cond)
+		if err != nil {
+			return 0, fmt.Errorf("rule %q: %s", r.Name, err)
+		}
+		n := count(matched.Goroutines())
+		if n > r.Threshold {
+			fired++
+			colorPrintf("[fg-red]FIRED[reset] %s (%s): %d goroutine(s) > %d\n", r.Name, r.Severity, n, r.Threshold)
+		} else {
+			colorPrintf("[fg-green]ok[reset]    %s (%s): %d goroutine(s) <= %d\n", r.Name, r.Severity, n, r.Threshold)
+		}
+	}
+	fmt.Printf("Rules fired: %d of %d.\n", fired, len(rules))
+	return fired, nil
+}
+
+// checkMain runs the rules in a file against dump files without the
+// interactive shell:
+//
+//	goroutine-inspect check <rules-file> <dump-file>...
+//
+// It returns the exit code: 0 if no rules fired, 1 if any rules fired, or 2
+// on errors. It runs with the default settings rather than the config file,
+// so the result is the same on every machine.
+func checkMain(args []string) int {
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "Usage: goroutine-inspect check <rules-file> <dump-file>...")
+		return 2
+	}
+	rules, dumps := args[0], args[1:]
+
+	code := 0
+	for _, fn := range dumps {
+		fmt.Printf("Checking %s:\n", fn)
+		gd, err := load(fn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error, %s.\n", err)
+			return 2
+		}
+		fired, err := gd.Check(rules)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error, %s.\n", err)
+			return 2
+		}
+		if fired > 0 {
+			code = 1
+		}
+		fmt.Println()
+	}
+	return code
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const sampleRules = `- name: workers piling up
+  severity: high
+  condition: has_frame('main.worker')
+  threshold: 2
+
+- name: long waits
+  condition: duration > 10
+  threshold: 2
+`
+
+func TestCheck(t *testing.T) {
+	newShell(t)
+	fn := writeFile(t, "rules.yaml", sampleRules)
+	want := `FIRED workers piling up (high): 3 goroutine(s) > 2
+ok    long waits (medium): 2 goroutine(s) <= 2
+Rules fired: 1 of 2.
+`
+
+	gd := mustLoad(t, sampleDump)
+	for _, dedup := range []bool{false, true} {
+		if dedup {
+			// A deduplicated goroutine counts as all of its duplicates.
+			captureStdout(t, func() {
+				if err := gd.Dedup("lines"); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+		var fired int
+		out := captureStdout(t, func() {
+			var err error
+			if fired, err = gd.Check(fn); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if fired != 1 || out != want {
+			t.Errorf("check() with dedup %v fired %d:\n%s\nwant:\n%s", dedup, fired, out, want)
+		}
+	}
+}
+
+func TestLoadRulesErrors(t *testing.T) {
+	newShell(t)
+	// Named conditions of the shell aren't used by the rules.
+	macros["waiting"] = "state == 'chan receive'"
+
+	for _, tc := range []struct {
+		rules, err string
+	}{
+		{"", "no rules in file"},
+		{"name: x", "invalid rules file"},
+		{"- condition: id == 1", "rule #1 has no name"},
+		{"- name: x\n  condition: id == 1\n  severity: urgent", `rule "x" has unknown severity urgent`},
+		{"- name: x", `rule "x" has no condition`},
+		{"- name: x\n  condition: id ==", `rule "x": Unexpected end of expression`},
+		{"- name: x\n  condition: waiting", `rule "x": waiting is neither`},
+		{"- name: x\n  condition: id == 1\n  threshold: -1", `rule "x" has negative threshold`},
+	} {
+		_, err := loadRules(writeFile(t, "rules.yaml", tc.rules))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("loadRules(%q) error = %v, want %q", tc.rules, err, tc.err)
+		}
+	}
+}
+
+func TestCheckMain(t *testing.T) {
+	newShell(t)
+	rules := writeFile(t, "rules.yaml", sampleRules)
+	fired := writeFile(t, "fired.txt", sampleDump)
+	ok := writeFile(t, "ok.txt", sampleDump[:strings.Index(sampleDump, "\n\n")+1])
+
+	for _, tc := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{rules, ok}, 0},
+		{[]string{rules, ok, fired}, 1},
+		{[]string{rules}, 2},
+		{[]string{rules, ok + ".missing"}, 2},
+		{[]string{writeFile(t, "bad.yaml", "- name: x"), ok}, 2},
+	} {
+		var code int
+		captureStdout(t, func() { code = checkMain(tc.args) })
+		if code != tc.code {
+			t.Errorf("checkMain(%q) = %d, want %d", tc.args, code, tc.code)
+		}
+	}
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/linuxerwang/goroutine-inspect/dump"
+)
+
+const (
+	// serveGroupLimit is the number of stack groups on a page by default.
+	serveGroupLimit = 50
+	// serveIdLimit is the number of goroutine ids listed for a stack group.
+	serveIdLimit = 20
+)
+
+// server serves a web UI to browse the dumps loaded from files. The pages
+// only read the dumps, so they're served concurrently under the read lock,
+// while the API loads, modifies and removes dumps under the write lock.
+type server struct {
+	mu    sync.RWMutex
+	names []string
+	dumps map[string]*GoroutineDump
+}
+
+// serveMain loads dump files and serves the web UI to browse them:
+//
+//	goroutine-inspect serve [--port <port>] <dump-file>...
+//
+// It returns the exit code, 2 on errors.
+func serveMain(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	port := fs.Int("port", 8080, "the port to listen on")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: goroutine-inspect serve [--port <port>] <dump-file>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	s := &server{dumps: map[string]*GoroutineDump{}}
+	for _, fn := range fs.Args() {
+		gd, err := load(fn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error, %s.\n", err)
+			return 2
+		}
+		s.add(filepath.Base(fn), gd)
+	}
+
+	// Only served locally, as dumps may have sensitive data.
+	addr := fmt.Sprintf("localhost:%d", *port)
+	fmt.Printf("Serving on http://%s/\n", addr)
+	if err := http.ListenAndServe(addr, s.handler()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error, %s.\n", err)
+		return 2
+	}
+	return 0
+}
+
+// add adds a dump by the name, or the name with a number if it's taken, e.g.
+// dumps of the same file name in different directories.
+func (s *server) add(name string, gd *GoroutineDump) {
+	unique := name
+	for i := 2; s.dumps[unique] != nil; i++ {
+		unique = fmt.Sprintf("%s (%d)", name, i)
+	}
+	s.set(unique, gd)
+	fmt.Printf("Loaded %s: %d goroutines.\n", unique, len(gd.Goroutines()))
+}
+
+// set sets the dump of the name, replacing the dump of the name if any.
+func (s *server) set(name string, gd *GoroutineDump) {
+	if _, ok := s.dumps[name]; !ok {
+		s.names = append(s.names, name)
+	}
+	s.dumps[name] = gd
+}
+
+// remove removes the dump of the name.
+func (s *server) remove(name string) {
+	delete(s.dumps, name)
+	for i, n := range s.names {
+		if n == name {
+			s.names = append(s.names[:i], s.names[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.readLocked(s.serveIndex))
+	mux.HandleFunc("/dump", s.readLocked(s.serveDump))
+	mux.HandleFunc("/diff", s.readLocked(s.serveDiff))
+	mux.HandleFunc("/goroutine", s.readLocked(s.serveGoroutine))
+	mux.Handle("/api/", &api{s})
+	return mux
+}
+
+// readLocked serves a page under the read lock of the dumps.
+func (s *server) readLocked(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		h(w, r)
+	}
+}
+
+// stat is the number of goroutines with a state or flag.
+type stat struct {
+	Key   string
+	Count int
+}
+
+// sortedStats returns the stats sorted by the count, the most first.
+func sortedStats(stats map[string]int) []stat {
+	l := make([]stat, 0, len(stats))
+	for k, n := range stats {
+		l = append(l, stat{k, n})
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Count != l[j].Count {
+			return l[i].Count > l[j].Count
+		}
+		return l[i].Key < l[j].Key
+	})
+	return l
+}
+
+// dedupModeNames returns the names of dump.DedupModes, sorted.
+func dedupModeNames() []string {
+	names := make([]string, 0, len(dump.DedupModes))
+	for k := range dump.DedupModes {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// groupView is a group of goroutines with the same stack on a page.
+type groupView struct {
+	Dump   string
+	Header string // The header of the first goroutine.
+	Count  int
+	IDs    []int // The first serveIdLimit ids.
+	More   int   // The number of ids not listed.
+	Delay  string
+	Trace  string
+}
+
+func newGroupView(name string, group []*dump.Goroutine) groupView {
+	g := group[0]
+	// A goroutine kept by dedup() counts as all of its duplicates.
+	v := groupView{Dump: name, Header: g.Header(), Count: count(group), Trace: g.Trace()}
+	for i, g := range group {
+		if i == serveIdLimit {
+			v.More = len(group) - i
+			break
+		}
+		v.IDs = append(v.IDs, g.ID())
+	}
+	// The delay of a stack is split across the goroutines by annotate().
+	var delay time.Duration
+	var contentions int64
+	for _, g := range group {
+		d, c := g.Delay()
+		delay, contentions = delay+d, contentions+c
+	}
+	if delay > 0 {
+		v.Delay = fmt.Sprintf("%s in %d contention(s)", delay.Round(time.Millisecond), contentions)
+	}
+	return v
+}
+
+func newGroupViews(name string, groups [][]*dump.Goroutine) []groupView {
+	views := make([]groupView, 0, len(groups))
+	for _, group := range groups {
+		views = append(views, newGroupView(name, group))
+	}
+	return views
+}
+
+// groupQuery is how a page filters, ranks and groups goroutines, by the URL
+// query parameters of the same names in lower case.
+type groupQuery struct {
+	Cond   string // A condition as in search().
+	Sort   string // A property as in sort(), or by group size if empty.
+	Mode   string // A dedup mode, by which goroutines are grouped.
+	Offset int
+	Limit  int
+}
+
+func parseGroupQuery(q url.Values) (*groupQuery, error) {
+	gq := &groupQuery{
+		Cond:  q.Get("cond"),
+		Sort:  q.Get("sort"),
+		Mode:  q.Get("mode"),
+		Limit: serveGroupLimit,
+	}
+	if gq.Mode == "" {
+		gq.Mode = settings.DedupMode
+	}
+	if _, ok := dump.DedupModes[gq.Mode]; !ok {
+		return nil, fmt.Errorf("unknown dedup mode %s", gq.Mode)
+	}
+	for name, p := range map[string]*int{"offset": &gq.Offset, "limit": &gq.Limit} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %s", name, v)
+			}
+			*p = n
+		}
+	}
+	return gq, nil
+}
+
+// filter returns the goroutines in the dump matching the condition, sorted
+// as queried. The dump is left unchanged.
+func (gq *groupQuery) filter(gd *GoroutineDump) (*GoroutineDump, error) {
+	view := &GoroutineDump{gd.GoroutineDump.Copy()}
+	if gq.Cond != "" {
+		matched, err := gd.filter(gq.Cond)
+		if err != nil {
+			return nil, err
+		}
+		view.GoroutineDump = matched
+	}
+	if gq.Sort != "" {
+		if err := view.Sort(gq.Sort); err != nil {
+			return nil, err
+		}
+	}
+	return view, nil
+}
+
+// groups groups the goroutines by stack, ranked by the sort order or the
+// group size.
+func (gq *groupQuery) groups(gd *GoroutineDump) [][]*dump.Goroutine {
+	groups := gd.GroupBy(dump.DedupModes[gq.Mode])
+	if gq.Sort == "" {
+		sort.SliceStable(groups, func(i, j int) bool {
+			return count(groups[i]) > count(groups[j])
+		})
+	}
+	return groups
+}
+
+// page returns the groups on the queried page, and the links to the previous
+// and the next pages, empty if none.
+func (gq *groupQuery) page(groups [][]*dump.Goroutine, q url.Values) ([][]*dump.Goroutine, string, string) {
+	link := func(offset int) string {
+		pq := url.Values{}
+		for k, v := range q {
+			pq[k] = v
+		}
+		pq.Set("offset", strconv.Itoa(offset))
+		return "?" + pq.Encode()
+	}
+
+	prev, next := "", ""
+	if gq.Offset > 0 {
+		offset := gq.Offset - gq.Limit
+		if offset < 0 {
+			offset = 0
+		}
+		prev = link(offset)
+	}
+	if gq.Offset >= len(groups) {
+		return nil, prev, next
+	}
+	end := gq.Offset + gq.Limit
+	if end < len(groups) {
+		next = link(end)
+	} else {
+		end = len(groups)
+	}
+	return groups[gq.Offset:end], prev, next
+}
+
+// render executes a page template, or replies with the error.
+func render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pageTemplates.ExecuteTemplate(w, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// lookup returns the dump by the name in the query parameter, or replies
+// with not found.
+func (s *server) lookup(w http.ResponseWriter, r *http.Request, param string) (string, *GoroutineDump, bool) {
+	name := r.URL.Query().Get(param)
+	gd, ok := s.dumps[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("dump %q not found", name), http.StatusNotFound)
+		return "", nil, false
+	}
+	return name, gd, true
+}
+
+func (s *server) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	type dumpInfo struct {
+		Name   string
+		Total  int
+		States []stat
+	}
+	var dumps []dumpInfo
+	for _, name := range s.names {
+		stats, _ := s.dumps[name].Stats()
+		dumps = append(dumps, dumpInfo{name, len(s.dumps[name].Goroutines()), sortedStats(stats)})
+	}
+	render(w, "index", map[string]interface{}{
+		"Title": "Goroutine Dumps",
+		"Dumps": dumps,
+		"Names": s.names,
+		"Modes": dedupModeNames(),
+	})
+}
+
+// serveDump shows the summary of a dump and its goroutines grouped by stack,
+// filtered and ranked by the query.
+func (s *server) serveDump(w http.ResponseWriter, r *http.Request) {
+	name, gd, ok := s.lookup(w, r, "name")
+	if !ok {
+		return
+	}
+	stats, flagStats := gd.Stats()
+	data := map[string]interface{}{
+		"Title":  name,
+		"Name":   name,
+		"Total":  len(gd.Goroutines()),
+		"States": sortedStats(stats),
+		"Flags":  sortedStats(flagStats),
+		"Modes":  dedupModeNames(),
+	}
+	defer render(w, "dump", data)
+
+	q := r.URL.Query()
+	gq, err := parseGroupQuery(q)
+	if err != nil {
+		data["Err"] = err.Error()
+		return
+	}
+	data["Query"] = gq
+	view, err := gq.filter(gd)
+	if err != nil {
+		data["Err"] = err.Error()
+		return
+	}
+	groups := gq.groups(view)
+	page, prev, next := gq.page(groups, q)
+	data["Matched"] = len(view.Goroutines())
+	data["GroupCount"] = len(groups)
+	data["Groups"] = newGroupViews(name, page)
+	data["Prev"], data["Next"] = prev, next
+}
+
+// serveDiff shows the goroutines only in either of two dumps side by side,
+// matched by goroutine id or stack as in subtract().
+func (s *server) serveDiff(w http.ResponseWriter, r *http.Request) {
+	left, ld, ok := s.lookup(w, r, "left")
+	if !ok {
+		return
+	}
+	right, rd, ok := s.lookup(w, r, "right")
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	key := q.Get("key")
+	if key == "" {
+		key = settings.DedupMode
+	}
+	data := map[string]interface{}{
+		"Title": left + " vs " + right,
+		"Left":  left,
+		"Right": right,
+		"Key":   key,
+		"Keys":  append([]string{"id"}, dedupModeNames()...),
+	}
+	defer render(w, "diff", data)
+
+	gq, err := parseGroupQuery(q)
+	if err != nil {
+		data["Err"] = err.Error()
+		return
+	}
+	data["Query"] = gq
+	if ld, err = gq.filter(ld); err != nil {
+		data["Err"] = err.Error()
+		return
+	}
+	if rd, err = gq.filter(rd); err != nil {
+		data["Err"] = err.Error()
+		return
+	}
+	lonly, err := ld.Subtract(rd, key)
+	if err != nil {
+		data["Err"] = err.Error()
+		return
+	}
+	ronly, _ := rd.Subtract(ld, key)
+	// Both sides are paged by the same offset, so there is a next page if
+	// either side has more groups.
+	lgroups, rgroups := gq.groups(lonly), gq.groups(ronly)
+	lpage, prev, next := gq.page(lgroups, q)
+	rpage, _, rnext := gq.page(rgroups, q)
+	if next == "" {
+		next = rnext
+	}
+	data["LeftOnly"], data["RightOnly"] = len(lonly.Goroutines()), len(ronly.Goroutines())
+	data["Common"] = len(ld.Goroutines()) - len(lonly.Goroutines())
+	data["LeftGroupCount"], data["RightGroupCount"] = len(lgroups), len(rgroups)
+	data["LeftGroups"], data["RightGroups"] = newGroupViews(left, lpage), newGroupViews(right, rpage)
+	data["Prev"], data["Next"] = prev, next
+}
+
+// serveGoroutine shows a goroutine by the dump name and the goroutine id,
+// for permalinks.
+func (s *server) serveGoroutine(w http.ResponseWriter, r *http.Request) {
+	name, gd, ok := s.lookup(w, r, "dump")
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "invalid goroutine id", http.StatusBadRequest)
+		return
+	}
+	for _, g := range gd.Goroutines() {
+		if g.ID() == id {
+			render(w, "goroutine", map[string]interface{}{
+				"Title":  fmt.Sprintf("Goroutine %d in %s", id, name),
+				"Name":   name,
+				"Group":  newGroupView(name, []*dump.Goroutine{g}),
+				"Labels": g.Labels(),
+			})
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("goroutine %d not found in %s", id, name), http.StatusNotFound)
+}
+
+var pageTemplates = template.Must(template.New("").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} - goroutine-inspect</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+pre { background: #f6f8fa; padding: .5em; overflow-x: auto; }
+input[type=text] { width: 30em; }
+.header { color: #0550ae; font-family: monospace; }
+.error { color: #cf222e; }
+.count { color: #cf222e; font-weight: bold; }
+.columns { display: flex; gap: 1em; }
+.columns > div { flex: 1; min-width: 0; }
+</style>
+</head>
+<body>
+<p><a href="/">Dumps</a></p>
+<h1>{{.Title}}</h1>
+{{if .Err}}<p class="error">Error, {{.Err}}.</p>{{end}}
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "group"}}<div>
+<p><span class="header">{{.Header}}</span>
+{{if gt .Count 1}}<span class="count">{{.Count}}</span> goroutines:{{end}}
+{{range .IDs}}<a href="/goroutine?dump={{$.Dump}}&amp;id={{.}}">{{.}}</a> {{end}}{{if .More}}and {{.More}} more{{end}}
+{{if .Delay}}<br>Delayed <span class="count">{{.Delay}}</span>{{end}}</p>
+<pre>{{.Trace}}</pre>
+</div>
+{{end}}
+
+{{define "stats"}}<table>
+{{range .}}<tr><td>{{.Key}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{define "index"}}{{template "header" .}}
+<table>
+<tr><th>Dump</th><th>Goroutines</th><th>Top states</th></tr>
+{{range .Dumps}}<tr>
+<td><a href="/dump?name={{.Name}}">{{.Name}}</a></td>
+<td>{{.Total}}</td>
+<td>{{range $i, $s := .States}}{{if lt $i 3}}{{$s.Key}}: {{$s.Count}}; {{end}}{{end}}</td>
+</tr>
+{{end}}</table>
+{{if gt (len .Names) 1}}<h2>Diff</h2>
+<form action="/diff">
+<select name="left">{{range .Names}}<option>{{.}}</option>{{end}}</select>
+<select name="right">{{range .Names}}<option>{{.}}</option>{{end}}</select>
+<input type="submit" value="Diff">
+</form>{{end}}
+{{template "footer" .}}{{end}}
+
+{{define "dump"}}{{template "header" .}}
+<p>{{.Total}} goroutines.</p>
+<div class="columns">
+<div><h2>States</h2>{{template "stats" .States}}</div>
+{{if .Flags}}<div><h2>Flags</h2>{{template "stats" .Flags}}</div>{{end}}
+</div>
+<form action="/dump">
+<input type="hidden" name="name" value="{{.Name}}">
+<p>Condition: <input type="text" name="cond" value="{{with .Query}}{{.Cond}}{{end}}" placeholder="e.g. state == 'select' &amp;&amp; duration > 30"></p>
+<p>Sort: <input type="text" name="sort" value="{{with .Query}}{{.Sort}}{{end}}" placeholder="by group size, or e.g. duration desc"></p>
+<p>Group by: <select name="mode">{{$mode := ""}}{{with .Query}}{{$mode = .Mode}}{{end}}{{range .Modes}}<option{{if eq . $mode}} selected{{end}}>{{.}}</option>{{end}}</select>
+<input type="submit" value="Show"></p>
+</form>
+{{if .Query}}{{if not .Err}}<p>{{.Matched}} goroutines matched, in {{.GroupCount}} groups.</p>{{end}}{{end}}
+{{range .Groups}}{{template "group" .}}{{end}}
+<p>{{if .Prev}}<a href="{{.Prev}}">Previous</a>{{end}} {{if .Next}}<a href="{{.Next}}">Next</a>{{end}}</p>
+{{template "footer" .}}{{end}}
+
+{{define "diff"}}{{template "header" .}}
+<form action="/diff">
+<input type="hidden" name="left" value="{{.Left}}">
+<input type="hidden" name="right" value="{{.Right}}">
+<p>Condition: <input type="text" name="cond" value="{{with .Query}}{{.Cond}}{{end}}"></p>
+<p>Match by: <select name="key">{{$key := .Key}}{{range .Keys}}<option{{if eq . $key}} selected{{end}}>{{.}}</option>{{end}}</select>
+<input type="submit" value="Diff"></p>
+</form>
+{{if not .Err}}<p>{{.Common}} goroutines of {{.Left}} matched in {{.Right}}.</p>
+<div class="columns">
+<div><h2>Only in {{.Left}}: {{.LeftOnly}} in {{.LeftGroupCount}} groups</h2>
+{{range .LeftGroups}}{{template "group" .}}{{end}}</div>
+<div><h2>Only in {{.Right}}: {{.RightOnly}} in {{.RightGroupCount}} groups</h2>
+{{range .RightGroups}}{{template "group" .}}{{end}}</div>
+</div>
+<p>{{if .Prev}}<a href="{{.Prev}}">Previous</a>{{end}} {{if .Next}}<a href="{{.Next}}">Next</a>{{end}}</p>{{end}}
+{{template "footer" .}}{{end}}
+
+{{define "goroutine"}}{{template "header" .}}
+<p><a href="/dump?name={{.Name}}">{{.Name}}</a></p>
+{{if .Labels}}<h2>Labels</h2>
+<table>{{range $k, $v := .Labels}}<tr><td>{{$k}}</td><td>{{$v}}</td></tr>{{end}}</table>{{end}}
+{{template "group" .Group}}
+{{template "footer" .}}{{end}}
+`))
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testServer returns a server of the sample dump as "a", and of another dump
+// as "b", which has goroutines 1, 5 and 7 of "a" and two more.
+func testServer(t *testing.T) *server {
+	t.Helper()
+	plainSettings(t)
+	b := strings.Replace(sampleDump, "goroutine 6 [chan receive, 12 minutes]:", "goroutine 8 [select]:", 1) + `
+goroutine 9 [IO wait]:
+main.serve()
+	/src/main.go:30 +0x50
+`
+	s := &server{dumps: map[string]*GoroutineDump{}}
+	captureStdout(t, func() {
+		s.add("a", mustLoad(t, sampleDump))
+		s.add("b", mustLoad(t, b))
+	})
+	return s
+}
+
+// get requests a page from the server, and returns the status code and the
+// body.
+func get(t *testing.T, s *server, path string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec.Code, rec.Body.String()
+}
+
+// checkPage checks that a page is served and has all the texts.
+func checkPage(t *testing.T, s *server, path string, texts ...string) {
+	t.Helper()
+	code, body := get(t, s, path)
+	if code != http.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", path, code, body)
+	}
+	for _, text := range texts {
+		if !strings.Contains(body, text) {
+			t.Errorf("GET %s: %q not in page:\n%s", path, text, body)
+		}
+	}
+}
+
+func TestServeIndex(t *testing.T) {
+	s := testServer(t)
+	checkPage(t, s, "/",
+		`<a href="/dump?name=a">a</a>`,
+		`<td>chan receive: 3; running: 1; </td>`,
+		`<select name="left"><option>a</option><option>b</option></select>`)
+
+	// Dumps of the same name are numbered.
+	captureStdout(t, func() { s.add("a", mustLoad(t, sampleDump)) })
+	checkPage(t, s, "/", `<a href="/dump?name=a%20%282%29">a (2)</a>`)
+}
+
+func TestServeDump(t *testing.T) {
+	s := testServer(t)
+	checkPage(t, s, "/dump?name=a",
+		"<p>4 goroutines.</p>",
+		"4 goroutines matched, in 3 groups.",
+		`<span class="count">2</span> goroutines:`)
+	checkPage(t, s, "/dump?name=a&mode=funcs",
+		"4 goroutines matched, in 2 groups.",
+		`<span class="count">3</span> goroutines:`)
+	checkPage(t, s, "/dump?name=a&cond=id+%3E+5",
+		"2 goroutines matched, in 2 groups.")
+
+	// The biggest group first, a page at a time.
+	checkPage(t, s, "/dump?name=a&limit=1",
+		`<a href="/goroutine?dump=a&amp;id=5">5</a> <a href="/goroutine?dump=a&amp;id=6">6</a>`,
+		`<a href="?limit=1&amp;name=a&amp;offset=1">Next</a>`)
+	checkPage(t, s, "/dump?name=a&limit=1&offset=2",
+		`<a href="?limit=1&amp;name=a&amp;offset=1">Previous</a>`)
+	if _, body := get(t, s, "/dump?name=a&limit=1&offset=2"); strings.Contains(body, "Next") {
+		t.Errorf("the last page links to a next page:\n%s", body)
+	}
+
+	for _, tc := range []struct {
+		query, err string
+	}{
+		{"cond=id+%3E", "Error, Unexpected end of expression."},
+		{"mode=words", "Error, unknown dedup mode words."},
+		{"limit=-1", "Error, invalid limit -1."},
+		{"sort=size", "Error, "},
+	} {
+		checkPage(t, s, "/dump?name=a&"+tc.query, tc.err)
+	}
+}
+
+func TestServeDiff(t *testing.T) {
+	s := testServer(t)
+	checkPage(t, s, "/diff?left=a&right=b&key=id",
+		"3 goroutines of a matched in b.",
+		"Only in a: 1 in 1 groups",
+		"Only in b: 2 in 2 groups",
+		`<a href="/goroutine?dump=a&amp;id=6">6</a>`,
+		`<a href="/goroutine?dump=b&amp;id=8">8</a>`)
+
+	// Both sides are paged together, so there is a next page while either
+	// side has more groups.
+	checkPage(t, s, "/diff?left=a&right=b&key=id&limit=1",
+		"Only in b: 2 in 2 groups",
+		`<a href="?key=id&amp;left=a&amp;limit=1&amp;offset=1&amp;right=b">Next</a>`)
+	code, body := get(t, s, "/diff?left=a&right=b&key=id&limit=1&offset=1")
+	if code != http.StatusOK || !strings.Contains(body, `<a href="?key=id&amp;left=a&amp;limit=1&amp;offset=0&amp;right=b">Previous</a>`) ||
+		strings.Contains(body, "Next") || strings.Contains(body, "id=6") {
+		t.Errorf("the second page of the diff:\n%s", body)
+	}
+
+	checkPage(t, s, "/diff?left=a&right=b&key=words", "Error, ")
+}
+
+func TestServeGoroutine(t *testing.T) {
+	s := testServer(t)
+	checkPage(t, s, "/goroutine?dump=b&id=9",
+		"<title>Goroutine 9 in b - goroutine-inspect</title>",
+		`<a href="/dump?name=b">b</a>`,
+		"main.serve()")
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/goroutine?dump=a&id=9", http.StatusNotFound},
+		{"/goroutine?dump=a&id=x", http.StatusBadRequest},
+		{"/goroutine?dump=c&id=1", http.StatusNotFound},
+		{"/dump?name=c", http.StatusNotFound},
+		{"/diff?left=a&right=c", http.StatusNotFound},
+		{"/favicon.ico", http.StatusNotFound},
+	} {
+		if code, body := get(t, s, tc.path); code != tc.code {
+			t.Errorf("GET %s: status %d, want %d: %s", tc.path, code, tc.code, body)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/linuxerwang/goroutine-inspect/dump"
+	"github.com/linuxerwang/goroutine-inspect/internal/text"
+)
+
+// sourceCandidates returns the local paths where the source file of a frame
+// may be, in the order to try:
+//
+//   - the path in the stack trace;
+//   - the path rewritten by the source_paths setting;
+//   - the standard library in the local GOROOT;
+//   - the local module cache, for paths in a module cache;
+//   - the local GOPATH, for paths in a GOPATH;
+//   - the current directory, with the leading directories of the path
+//     removed one by one but the last, for a checkout of the code.
+func sourceCandidates(f *dump.Frame) []string {
+	file := filepath.ToSlash(f.File())
+	candidates := []string{file}
+
+	if path, ok := text.RewritePrefix(file, settings.SourcePaths); ok {
+		candidates = append(candidates, path)
+	}
+
+	if dump.IsStdPackage(f.Package()) {
+		if idx := strings.LastIndex(file, "/src/"+f.Package()+"/"); idx >= 0 {
+			candidates = append(candidates, filepath.Join(goroot(), file[idx+1:]))
+		}
+	}
+	if idx := strings.Index(file, "/pkg/mod/"); idx >= 0 {
+		candidates = append(candidates, filepath.Join(modCache(), file[idx+len("/pkg/mod/"):]))
+	} else if idx := strings.Index(file, "/src/"); idx >= 0 {
+		candidates = append(candidates, filepath.Join(gopath(), file[idx+1:]))
+	}
+
+	// Not the base name alone, which likely matches another file.
+	for rest := strings.TrimPrefix(file, "/"); strings.Contains(rest, "/"); {
+		candidates = append(candidates, rest)
+		rest = rest[strings.Index(rest, "/")+1:]
+	}
+	return candidates
+}
+
+// goroot returns the local GOROOT.
+func goroot() string {
+	if dir := os.Getenv("GOROOT"); dir != "" {
+		return dir
+	}
+	return runtime.GOROOT()
+}
+
+// gopath returns the first directory of the local GOPATH.
+func gopath() string {
+	if dir := os.Getenv("GOPATH"); dir != "" {
+		return filepath.SplitList(dir)[0]
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "go")
+}
+
+// modCache returns the local module cache directory.
+func modCache() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	return filepath.Join(gopath(), "pkg", "mod")
+}
+
+// Source prints the source code around the line of a frame of a goroutine,
+// with context lines before and after it.
+func (gd *GoroutineDump) Source(id, frame, context int) error {
+	var g *dump.Goroutine
+	for _, v := range gd.Goroutines() {
+		if v.ID() == id {
+			g = v
+			break
+		}
+	}
+	if g == nil {
+		return fmt.Errorf("goroutine %d not found", id)
+	}
+	if frame < 0 || frame >= len(g.Frames()) {
+		return fmt.Errorf("goroutine %d has frames [0, %d)", id, len(g.Frames()))
+	}
+	f := g.Frames()[frame]
+	if f.File() == "" || f.Line() <= 0 {
+		return fmt.Errorf("frame %d of goroutine %d has no source line", frame, id)
+	}
+
+	var file *os.File
+	for _, fn := range sourceCandidates(f) {
+		var err error
+		if file, err = os.Open(fn); err == nil {
+			break
+		}
+	}
+	if file == nil {
+		return fmt.Errorf("source file %s not found, map its directory with the source_paths setting", f.File())
+	}
+	defer file.Close()
+
+	colorPrintf("[fg-blue]%s[reset]\n", f.Func())
+	colorPrintf("[fg-blue]%s:%d[reset]\n", file.Name(), f.Line())
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for n := 1; scanner.Scan() && n <= f.Line()+context; n++ {
+		if n < f.Line()-context {
+			continue
+		}
+		if n == f.Line() {
+			colorPrintf("[fg-yellow]%5d> %s[reset]\n", n, scanner.Text())
+		} else {
+			fmt.Printf("%5d  %s\n", n, scanner.Text())
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSourceCandidates(t *testing.T) {
+	plainSettings(t)
+	t.Setenv("GOROOT", "/goroot")
+	t.Setenv("GOPATH", "/gopath:/other")
+	t.Setenv("GOMODCACHE", "")
+	settings.SourcePaths["/builds/ci/"] = "/home/me/"
+
+	for _, tc := range []struct {
+		dump string
+		want []string
+	}{
+		{
+			"goroutine 1 [select]:\nnet/http.(*conn).serve(0xc000180000)\n\t/usr/local/go/src/net/http/server.go:2009 +0x5f4\n",
+			[]string{
+				"/usr/local/go/src/net/http/server.go",
+				"/goroot/src/net/http/server.go",
+				"/gopath/src/net/http/server.go",
+				"usr/local/go/src/net/http/server.go",
+				"local/go/src/net/http/server.go",
+				"go/src/net/http/server.go",
+				"src/net/http/server.go",
+				"net/http/server.go",
+				"http/server.go",
+			},
+		},
+		{
+			"goroutine 1 [select]:\ngoogle.golang.org/grpc.(*Server).serve(0xc000180000)\n\t/root/go/pkg/mod/google.golang.org/grpc@v1.60.0/server.go:900 +0x5f4\n",
+			[]string{
+				"/root/go/pkg/mod/google.golang.org/grpc@v1.60.0/server.go",
+				"/gopath/pkg/mod/google.golang.org/grpc@v1.60.0/server.go",
+				"root/go/pkg/mod/google.golang.org/grpc@v1.60.0/server.go",
+				"go/pkg/mod/google.golang.org/grpc@v1.60.0/server.go",
+				"pkg/mod/google.golang.org/grpc@v1.60.0/server.go",
+				"mod/google.golang.org/grpc@v1.60.0/server.go",
+				"google.golang.org/grpc@v1.60.0/server.go",
+				"grpc@v1.60.0/server.go",
+			},
+		},
+		{
+			"goroutine 1 [select]:\nmain.main()\n\t/builds/ci/svc/main.go:10 +0x20\n",
+			[]string{
+				"/builds/ci/svc/main.go",
+				"/home/me/svc/main.go",
+				"builds/ci/svc/main.go",
+				"ci/svc/main.go",
+				"svc/main.go",
+			},
+		},
+	} {
+		f := mustLoad(t, tc.dump).Goroutines()[0].Frames()[0]
+		if got := sourceCandidates(f); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("sourceCandidates(%s) = %q, want %q", f.Func(), got, tc.want)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	plainSettings(t)
+	dir := t.TempDir()
+	var src strings.Builder
+	for _, l := range []string{"package main", "", "func main() {", "\tselect {}", "}"} {
+		src.WriteString(l + "\n")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(src.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	settings.SourcePaths["/builds/ci/"] = dir + "/"
+	gd := mustLoad(t, "goroutine 1 [select (no cases)]:\nmain.main()\n\t/builds/ci/main.go:4 +0x20\n")
+
+	want := "main.main\n" + filepath.Join(dir, "main.go") + ":4\n" +
+		"    3  func main() {\n" +
+		"    4> \tselect {}\n" +
+		"    5  }\n"
+	out := captureStdout(t, func() {
+		if err := gd.Source(1, 0, 1); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if out != want {
+		t.Errorf("source(1, 0, 1) printed:\n%s\nwant:\n%s", out, want)
+	}
+
+	for _, tc := range []struct {
+		id, frame int
+		err       string
+	}{
+		{2, 0, "goroutine 2 not found"},
+		{1, 1, "goroutine 1 has frames [0, 1)"},
+		{1, -1, "goroutine 1 has frames [0, 1)"},
+	} {
+		if err := gd.Source(tc.id, tc.frame, 1); err == nil || err.Error() != tc.err {
+			t.Errorf("source(%d, %d) error = %v, want %q", tc.id, tc.frame, err, tc.err)
+		}
+	}
+
+	delete(settings.SourcePaths, "/builds/ci/")
+	if err := gd.Source(1, 0, 1); err == nil || !strings.HasPrefix(err.Error(), "source file /builds/ci/main.go not found") {
+		t.Errorf("source() without source_paths error = %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/linuxerwang/goroutine-inspect/dump"
+)
+
+const (
+	// suspectGroupSize is the number of goroutines with the same stack from
+	// which a group is suspicious.
+	suspectGroupSize = 10
+	// suspectMinutes is how long goroutines are blocked from which they're
+	// suspicious.
+	suspectMinutes = 10
+	// suspectQueueSize is the number of goroutines waiting for the same
+	// lock from which the queue is suspicious.
+	suspectQueueSize = 50
+)
+
+// finding is a group of goroutines found suspicious by a heuristic.
+type finding struct {
+	title       string
+	explanation string
+	goroutines  []*dump.Goroutine
+	severity    int // One of severityLow, severityMedium and severityHigh.
+}
+
+const (
+	severityLow = iota
+	severityMedium
+	severityHigh
+)
+
+var severityNames = []string{"low", "medium", "high"}
+
+// heuristic tells if a group of goroutines with the same stack is
+// suspicious.
+type heuristic func(group []*dump.Goroutine) *finding
+
+var heuristics = []heuristic{
+	// Blocked forever.
+	func(group []*dump.Goroutine) *finding {
+		g := group[0]
+		if !strings.HasSuffix(g.State(), "(nil chan)") {
+			return nil
+		}
+		return &finding{
+			title:       "Blocked on a nil channel",
+			explanation: "Sending to or receiving from a nil channel blocks forever. The channel is probably never initialized, or set to nil after closing.",
+			goroutines:  group,
+			severity:    severityHigh,
+		}
+	},
+	func(group []*dump.Goroutine) *finding {
+		g := group[0]
+		if g.State() != "select (no cases)" || g.OwnTopFunc() == "main.main" {
+			return nil
+		}
+		return &finding{
+			title:       "Blocked in an empty select",
+			explanation: "\"select {}\" blocks forever. Outside of main(), the goroutine can never exit.",
+			goroutines:  group,
+			severity:    severityHigh,
+		}
+	},
+	// Leaks.
+	func(group []*dump.Goroutine) *finding {
+		g := group[0]
+		if g.State() != "chan send" && g.State() != "chan receive" {
+			return nil
+		}
+		long := blockedFor(group, suspectMinutes)
+		if count(long) < suspectGroupSize {
+			return nil
+		}
+		side := "receiver"
+		if g.State() == "chan receive" {
+			side = "sender"
+		}
+		return &finding{
+			title: fmt.Sprintf("Blocked on %s for %d+ minutes", g.State(), suspectMinutes),
+			explanation: fmt.Sprintf("Many goroutines waiting on the same channel operation for long usually means the %s has gone, "+
+				"e.g. returned on an error or a timeout, which leaks the goroutines of unbuffered channels. See channels().", side),
+			goroutines: long,
+			severity:   severityMedium,
+		}
+	},
+	func(group []*dump.Goroutine) *finding {
+		g := group[0]
+		if g.State() != "sync.WaitGroup.Wait" && g.State() != "sync.Cond.Wait" && g.State() != "semacquire" &&
+			!strings.HasPrefix(g.State(), "sync.Mutex.") && !strings.HasPrefix(g.State(), "sync.RWMutex.") {
+			return nil
+		}
+		if count(group) < suspectQueueSize {
+			return nil
+		}
+		return &finding{
+			title: fmt.Sprintf("Large queue in %s", g.State()),
+			explanation: "Many goroutines waiting at the same place on a sync primitive means heavy lock contention, " +
+				"or a holder which never unlocks or a WaitGroup which is never done. See locks().",
+			goroutines: group,
+			severity:   severityMedium,
+		}
+	},
+	func(group []*dump.Goroutine) *finding {
+		g := group[0]
+		if g.State() != "IO wait" {
+			return nil
+		}
+		long := blockedFor(group, suspectMinutes)
+		if count(long) < suspectGroupSize {
+			return nil
+		}
+		return &finding{
+			title: fmt.Sprintf("In IO wait for %d+ minutes", suspectMinutes),
+			explanation: "Many connections waiting for IO for long usually have no read or write deadline, " +
+				"so goroutines pile up on idle or dead peers. Set deadlines or timeouts on the connections.",
+			goroutines: long,
+			severity:   severityLow,
+		}
+	},
+	func(group []*dump.Goroutine) *finding {
+		g := group[0]
+		if g.TopFunc() != "time.Sleep" || count(group) < suspectGroupSize {
+			return nil
+		}
+		return &finding{
+			title: "Sleeping at the same place",
+			explanation: "Many goroutines in time.Sleep at the same place are usually polling loops. " +
+				"They leak if they're started more than once and never stopped, e.g. by a context.",
+			goroutines: group,
+			severity:   severityLow,
+		}
+	},
+}
+
+// count returns the number of goroutines the ones in a group stand for,
+// counting the duplicates of the deduplicated ones.
+func count(group []*dump.Goroutine) int {
+	n := 0
+	for _, g := range group {
+		n += g.Count()
+	}
+	return n
+}
+
+// blockedFor returns the goroutines blocked for at least the minutes.
+func blockedFor(group []*dump.Goroutine, minutes int) []*dump.Goroutine {
+	var long []*dump.Goroutine
+	for _, g := range group {
+		if g.Duration() >= minutes {
+			long = append(long, g)
+		}
+	}
+	return long
+}
+
+// Suspects groups the goroutines by stack trace as Dedup() does, applies the
+// heuristics to each group, and prints the findings ranked by severity and
+// size, with the representative stack of each. A goroutine deduplicated
+// before counts as all of its duplicates.
+func (gd *GoroutineDump) Suspects() {
+	var findings []*finding
+	for _, group := range gd.GroupBy(dump.DedupModes[settings.DedupMode]) {
+		for _, h := range heuristics {
+			if f := h(group); f != nil {
+				findings = append(findings, f)
+			}
+		}
+	}
+	if len(findings) == 0 {
+		fmt.Println("No suspects found.")
+		return
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].severity != findings[j].severity {
+			return findings[i].severity > findings[j].severity
+		}
+		return count(findings[i].goroutines) > count(findings[j].goroutines)
+	})
+
+	for i, f := range findings {
+		longest := 0
+		for _, g := range f.goroutines {
+			if g.Duration() > longest {
+				longest = g.Duration()
+			}
+		}
+		colorPrintf("[fg-red]#%d %s[reset] (%s): %d goroutine(s)", i+1, f.title, severityNames[f.severity], count(f.goroutines))
+		if longest > 0 {
+			fmt.Printf(", up to %d minutes", longest)
+		}
+		fmt.Println()
+		fmt.Println(f.explanation)
+		fmt.Println()
+
+		// Print the stack without the ids of all the duplicates.
+		printGoroutine(f.goroutines[0], nil)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// repeatGoroutine returns n goroutines with the ids from first on, in the
+// state, e.g. "chan receive, 15 minutes", with the same stack.
+func repeatGoroutine(first, n int, state, stack string) string {
+	var b strings.Builder
+	for id := first; id < first+n; id++ {
+		fmt.Fprintf(&b, "goroutine %d [%s]:\n%s\n", id, state, stack)
+	}
+	return b.String()
+}
+
+const (
+	recvStack = `main.consume(0xc000010000)
+	/src/main.go:20 +0x30
+created by main.main in goroutine 1
+	/src/main.go:12 +0x40
+`
+	lockStack = `sync.runtime_SemacquireMutex(0xc000012008, 0x0, 0x1)
+	/usr/local/go/src/runtime/sema.go:77 +0x25
+sync.(*Mutex).lockSlow(0xc000012000)
+	/usr/local/go/src/sync/mutex.go:171 +0x15d
+sync.(*Mutex).Lock(...)
+	/usr/local/go/src/sync/mutex.go:90
+main.update()
+	/src/main.go:30 +0x4a
+created by main.main in goroutine 1
+	/src/main.go:14 +0x40
+`
+	sleepStack = `time.Sleep(0x3b9aca00)
+	/usr/local/go/src/runtime/time.go:195 +0x125
+main.poll()
+	/src/main.go:40 +0x2a
+created by main.main in goroutine 1
+	/src/main.go:16 +0x40
+`
+	nilStack = `main.wait()
+	/src/main.go:50 +0x1a
+created by main.main in goroutine 1
+	/src/main.go:18 +0x40
+`
+)
+
+func TestSuspects(t *testing.T) {
+	plainSettings(t)
+	gd := mustLoad(t, repeatGoroutine(10, 12, "chan receive, 15 minutes", recvStack)+
+		repeatGoroutine(30, 50, "sync.Mutex.Lock", lockStack)+
+		repeatGoroutine(90, 1, "chan receive (nil chan), 3 minutes", nilStack))
+
+	want := `#1 Blocked on a nil channel (high): 1 goroutine(s), up to 3 minutes
+Sending to or receiving from a nil channel blocks forever. The channel is probably never initialized, or set to nil after closing.
+
+goroutine 90 [chan receive (nil chan), 3 minutes]:
+` + nilStack + `
+#2 Large queue in sync.Mutex.Lock (medium): 50 goroutine(s)
+Many goroutines waiting at the same place on a sync primitive means heavy lock contention, or a holder which never unlocks or a WaitGroup which is never done. See locks().
+
+goroutine 30 [sync.Mutex.Lock]:
+` + lockStack + `
+#3 Blocked on chan receive for 10+ minutes (medium): 12 goroutine(s), up to 15 minutes
+Many goroutines waiting on the same channel operation for long usually means the sender has gone, e.g. returned on an error or a timeout, which leaks the goroutines of unbuffered channels. See channels().
+
+goroutine 10 [chan receive, 15 minutes]:
+` + recvStack + `
+`
+	if out := captureStdout(t, gd.Suspects); out != want {
+		t.Errorf("suspects() printed:\n%s\nwant:\n%s", out, want)
+	}
+
+	// A deduplicated goroutine counts as all of its duplicates.
+	if err := gd.Dedup("lines"); err != nil {
+		t.Fatal(err)
+	}
+	if out := captureStdout(t, gd.Suspects); out != want {
+		t.Errorf("suspects() after dedup() printed:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestSuspectsThresholds(t *testing.T) {
+	plainSettings(t)
+	for _, tc := range []struct {
+		name, dump, want string
+	}{
+		{"few receivers", repeatGoroutine(10, 9, "chan receive, 15 minutes", recvStack), ""},
+		{"recent receivers", repeatGoroutine(10, 12, "chan receive, 5 minutes", recvStack), ""},
+		{"short queue", repeatGoroutine(10, 49, "sync.Mutex.Lock", lockStack), ""},
+		{"sleepers", repeatGoroutine(10, 10, "sleep", sleepStack), "#1 Sleeping at the same place (low): 10 goroutine(s)"},
+		{"empty select", repeatGoroutine(10, 1, "select (no cases)", nilStack), "#1 Blocked in an empty select (high): 1 goroutine(s)"},
+		{"empty select in main", "goroutine 1 [select (no cases)]:\nmain.main()\n\t/src/main.go:10 +0x20\n", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := captureStdout(t, mustLoad(t, tc.dump).Suspects)
+			if tc.want == "" && out != "No suspects found.\n" {
+				t.Errorf("suspects() printed:\n%s\nwant no suspects", out)
+			}
+			if tc.want != "" && !strings.HasPrefix(out, tc.want+"\n") {
+				t.Errorf("suspects() printed:\n%s\nwant %q", out, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// setVar assigns a dump to a variable in the workspace, replacing any
+// snapshot list of the same name.
+func setVar(name string, gd *GoroutineDump) {
+	delete(snapshots, name)
+	workspace[name] = gd
+}
+
+// setSnapshots assigns a list of dumps to a variable in the workspace,
+// replacing any dump of the same name.
+func setSnapshots(name string, gds []*GoroutineDump) {
+	delete(workspace, name)
+	snapshots[name] = gds
+}
+
+// isVar tells if gd is assigned to a variable, or is in a snapshot list.
+func isVar(gd *GoroutineDump) bool {
+	for _, v := range workspace {
+		if v == gd {
+			return true
+		}
+	}
+	for _, gds := range snapshots {
+		for _, v := range gds {
+			if v == gd {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// varNames returns the sorted names of all variables in the workspace.
+func varNames() []string {
+	names := make([]string, 0, len(workspace)+len(snapshots))
+	for k := range workspace {
+		names = append(names, k)
+	}
+	for k, gds := range snapshots {
+		names = append(names, fmt.Sprintf("%s[0..%d]", k, len(gds)-1))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// printSnapshots prints the summary line of each dump in a snapshot list.
+func printSnapshots(name string, gds []*GoroutineDump) {
+	fmt.Printf("# of dumps: %d\n\n", len(gds))
+	for i, gd := range gds {
+		fmt.Printf("%15s: %d goroutines\n", fmt.Sprintf("%s[%d]", name, i), len(gd.Goroutines()))
+	}
+	fmt.Println()
+}